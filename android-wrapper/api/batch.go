@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"sync"
+
+	"mihomo_android_wrapper/contract"
+)
+
+// dispatchBatch runs params.Actions through Dispatch and returns one response per action, in order.
+// Nested batches, and StopOnError with Parallel, are rejected by BatchParams.Validate.
+// With StopOnError, the first failure skips the actions after it.
+// The returned func runs the AfterSend hooks of all sub-actions.
+func (d *Dispatcher) dispatchBatch(ctx context.Context, params contract.BatchParams) ([]contract.Response, func(), error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]DispatchResult, len(params.Actions))
+	run := func(i int) {
+		results[i] = d.Dispatch(ctx, params.Actions[i])
+		if params.StopOnError && results[i].Response.Code != 0 {
+			cancel()
+		}
+	}
+
+	if params.Parallel {
+		var wg sync.WaitGroup
+		for i := range params.Actions {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				run(i)
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i, action := range params.Actions {
+			if ctx.Err() != nil {
+				results[i] = skipped(action, ctx.Err())
+				continue
+			}
+			run(i)
+		}
+	}
+
+	responses := make([]contract.Response, len(results))
+	var afterSend []func()
+	for i, r := range results {
+		responses[i] = r.Response
+		if r.AfterSend != nil {
+			afterSend = append(afterSend, r.AfterSend)
+		}
+	}
+	if len(afterSend) == 0 {
+		return responses, nil, nil
+	}
+	return responses, func() {
+		for _, fn := range afterSend {
+			fn()
+		}
+	}, nil
+}
+
+// skipped builds the response for a batch action that was never started.
+func skipped(action contract.Action, err error) DispatchResult {
+	return DispatchResult{
+		Response: contract.Response{
+			ID:     action.ID,
+			Method: action.Method,
+			Data:   contract.AsError(err).WithDetails(map[string]bool{"skipped": true}),
+			Code:   -1,
+		},
+	}
+}
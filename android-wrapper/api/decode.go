@@ -3,8 +3,47 @@ package api
 import (
 	"encoding/json"
 	"errors"
+
+	"mihomo_android_wrapper/contract"
 )
 
+// Decoder converts raw action data into typed params.
+type Decoder[P any] func(raw json.RawMessage) (P, error)
+
+// untyped adapts a typed Decoder to the form used by Dispatcher.Handle.
+func untyped[P any](decode Decoder[P]) func(raw json.RawMessage) (any, error) {
+	return func(raw json.RawMessage) (any, error) {
+		return decode(raw)
+	}
+}
+
+// noParams ignores action data for methods without params.
+func noParams(json.RawMessage) (struct{}, error) {
+	return struct{}{}, nil
+}
+
+// rawParams passes action data through as a JSON string.
+func rawParams(raw json.RawMessage) (string, error) {
+	return string(raw), nil
+}
+
+// jsonParams decodes a JSON object into P.
+func jsonParams[P any](raw json.RawMessage) (P, error) {
+	var params P
+	err := decodeJSON(raw, &params)
+	return params, err
+}
+
+// optionalParams decodes a JSON object into P, using the zero value when data is absent or null.
+func optionalParams[P any](raw json.RawMessage) (P, error) {
+	var params P
+	if len(raw) == 0 || string(raw) == "null" {
+		return params, nil
+	}
+	err := json.Unmarshal(raw, &params)
+	return params, err
+}
+
 // decodeJSON decodes JSON into dst.
 func decodeJSON(raw json.RawMessage, dst any) error {
 	if len(raw) == 0 || string(raw) == "null" {
@@ -34,3 +73,8 @@ func decodeBool(raw json.RawMessage) (bool, error) {
 	}
 	return value, nil
 }
+
+// invalidParams reports a params decoding failure for method as ErrInvalidParams.
+func invalidParams(method contract.Method, err error) *contract.Error {
+	return contract.Errorf(contract.ErrInvalidParams, "%s: invalid params: %s", method, err.Error())
+}
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+
 	"mihomo_android_wrapper/contract"
 )
 
@@ -9,192 +12,118 @@ type DispatchResult struct {
 	AfterSend func()
 }
 
+// Request is a routed action as seen by middleware and handlers.
+type Request struct {
+	Action contract.Action
+	// Params holds the decoded action data (nil if decoding failed or the method is unknown).
+	Params any
+	// AfterSend, when set by a handler, runs after the response has been sent.
+	AfterSend func()
+}
+
+// HandlerFunc handles a routed request; the returned data becomes the response data.
+type HandlerFunc func(ctx context.Context, req *Request) (any, error)
+
+// Middleware wraps a handler to add cross-cutting behavior.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+type route struct {
+	decode func(raw json.RawMessage) (any, error)
+	handle HandlerFunc
+}
+
+// Dispatcher routes contract.Action values to registered handlers through a middleware chain.
+// Handle, Register and Use are not safe for concurrent use with Dispatch; configure routes first.
 type Dispatcher struct {
 	Service contract.Service
+
+	routes      map[contract.Method]route
+	order       []contract.Method
+	middlewares []Middleware
+	inflight    inflight
 }
 
 // New creates a Dispatcher that routes contract.Action to Service.
+// Panic recovery and params validation are installed by default.
 func New(service contract.Service) *Dispatcher {
-	return &Dispatcher{Service: service}
+	d := &Dispatcher{Service: service}
+	d.Use(Recover(), Validate())
+	d.registerRoutes()
+	return d
+}
+
+// Use appends middleware; the first middleware added is the outermost.
+func (d *Dispatcher) Use(middlewares ...Middleware) {
+	d.middlewares = append(d.middlewares, middlewares...)
+}
+
+// Handle routes method to handler; decode converts the action data into Request.Params.
+// Registering a method again replaces its handler.
+func (d *Dispatcher) Handle(method contract.Method, decode func(raw json.RawMessage) (any, error), handler HandlerFunc) {
+	if d.routes == nil {
+		d.routes = make(map[contract.Method]route)
+	}
+	if _, exists := d.routes[method]; !exists {
+		d.order = append(d.order, method)
+	}
+	d.routes[method] = route{decode: decode, handle: handler}
+}
+
+// Register routes method to a handler that receives the action data decoded by decode.
+func Register[P any](d *Dispatcher, method contract.Method, decode Decoder[P], handler func(ctx context.Context, params P) (any, error)) {
+	d.Handle(method, untyped(decode), func(ctx context.Context, req *Request) (any, error) {
+		return handler(ctx, req.Params.(P))
+	})
+}
+
+// Methods returns the registered methods in registration order.
+func (d *Dispatcher) Methods() []contract.Method {
+	return append([]contract.Method(nil), d.order...)
 }
 
-// Dispatch routes an Action to Service and builds a response.
+// Dispatch routes an Action to its handler and builds a response.
+// The action runs under a context derived from ctx that honors action.TimeoutMs and cancelAction.
 // AfterSend is used for side effects that must happen after the response is sent (for example, crash tests).
-func (d *Dispatcher) Dispatch(action contract.Action) DispatchResult {
+func (d *Dispatcher) Dispatch(ctx context.Context, action contract.Action) DispatchResult {
+	ctx, done := d.inflight.start(ctx, action)
+	defer done()
+
+	req := &Request{Action: action}
+	handler := unknownMethod
+	if r, ok := d.routes[action.Method]; ok {
+		params, err := r.decode(action.Data)
+		if err != nil {
+			handler = func(context.Context, *Request) (any, error) {
+				return nil, invalidParams(action.Method, err)
+			}
+		} else {
+			req.Params = params
+			handler = r.handle
+		}
+	}
+	for i := len(d.middlewares) - 1; i >= 0; i-- {
+		handler = d.middlewares[i](handler)
+	}
+
+	data, err := handler(ctx, req)
+
 	result := DispatchResult{
 		Response: contract.Response{
 			ID:     action.ID,
 			Method: action.Method,
+			Data:   data,
 			Code:   0,
 		},
+		AfterSend: req.AfterSend,
 	}
-
-	fail := func(data any) DispatchResult {
+	if err != nil {
 		result.Response.Code = -1
-		result.Response.Data = data
-		return result
-	}
-
-	success := func(data any) DispatchResult {
-		result.Response.Code = 0
-		result.Response.Data = data
-		return result
+		result.Response.Data = contract.AsError(err)
 	}
+	return result
+}
 
-	switch action.Method {
-	case contract.InitClashMethod:
-		var params contract.InitParams
-		if err := decodeJSON(action.Data, &params); err != nil {
-			return fail("initClash: invalid params: " + err.Error())
-		}
-		return success(d.Service.InitClash(params))
-	case contract.GetVersionMethod:
-		return success(d.Service.GetVersion())
-	case contract.GetIsInitMethod:
-		return success(d.Service.GetIsInit())
-	case contract.ForceGcMethod:
-		d.Service.ForceGC()
-		return success(true)
-	case contract.ShutdownMethod:
-		return success(d.Service.Shutdown())
-	case contract.ValidateConfigMethod:
-		path, err := decodeString(action.Data)
-		if err != nil {
-			return fail("validateConfig: invalid params: " + err.Error())
-		}
-		return success(d.Service.ValidateConfig(path))
-	case contract.GetConfigMethod:
-		path, err := decodeString(action.Data)
-		if err != nil {
-			return fail("getConfig: invalid params: " + err.Error())
-		}
-		cfg, err := d.Service.GetConfig(path)
-		if err != nil {
-			return fail(err.Error())
-		}
-		return success(cfg)
-	case contract.UpdateConfigMethod:
-		payload, err := decodeString(action.Data)
-		if err != nil {
-			return fail("updateConfig: invalid params: " + err.Error())
-		}
-		return success(d.Service.UpdateConfig(payload))
-	case contract.SetupConfigMethod:
-		return success(d.Service.SetupConfig(string(action.Data)))
-	case contract.GetProxiesMethod:
-		return success(d.Service.GetProxies())
-	case contract.ChangeProxyMethod:
-		var params contract.ChangeProxyParams
-		if err := decodeJSON(action.Data, &params); err != nil {
-			return fail("changeProxy: invalid params: " + err.Error())
-		}
-		return success(d.Service.ChangeProxy(params))
-	case contract.GetTrafficMethod:
-		onlyProxy, err := decodeBool(action.Data)
-		if err != nil {
-			return fail("getTraffic: invalid params: " + err.Error())
-		}
-		return success(d.Service.GetTraffic(onlyProxy))
-	case contract.GetTotalTrafficMethod:
-		onlyProxy, err := decodeBool(action.Data)
-		if err != nil {
-			return fail("getTotalTraffic: invalid params: " + err.Error())
-		}
-		return success(d.Service.GetTotalTraffic(onlyProxy))
-	case contract.ResetTrafficMethod:
-		d.Service.ResetTraffic()
-		return success(true)
-	case contract.AsyncTestDelayMethod:
-		data, err := decodeString(action.Data)
-		if err != nil {
-			return fail("asyncTestDelay: invalid params: " + err.Error())
-		}
-		return success(d.Service.AsyncTestDelay(data))
-	case contract.GetConnectionsMethod:
-		return success(d.Service.GetConnections())
-	case contract.CloseConnectionsMethod:
-		return success(d.Service.CloseConnections())
-	case contract.ResetConnectionsMethod:
-		return success(d.Service.ResetConnections())
-	case contract.CloseConnectionMethod:
-		id, err := decodeString(action.Data)
-		if err != nil {
-			return fail("closeConnection: invalid params: " + err.Error())
-		}
-		return success(d.Service.CloseConnection(id))
-	case contract.GetExternalProvidersMethod:
-		return success(d.Service.GetExternalProviders())
-	case contract.GetExternalProviderMethod:
-		name, err := decodeString(action.Data)
-		if err != nil {
-			return fail("getExternalProvider: invalid params: " + err.Error())
-		}
-		return success(d.Service.GetExternalProvider(name))
-	case contract.UpdateGeoDataMethod:
-		payload, err := decodeString(action.Data)
-		if err != nil {
-			return fail("updateGeoData: invalid params: " + err.Error())
-		}
-		return success(d.Service.UpdateGeoData(payload))
-	case contract.SideLoadExternalProviderMethod:
-		payload, err := decodeString(action.Data)
-		if err != nil {
-			return fail("sideLoadExternalProvider: invalid params: " + err.Error())
-		}
-		return success(d.Service.SideLoadExternalProvider(payload))
-	case contract.UpdateExternalProviderMethod:
-		name, err := decodeString(action.Data)
-		if err != nil {
-			return fail("updateExternalProvider: invalid params: " + err.Error())
-		}
-		return success(d.Service.UpdateExternalProvider(name))
-	case contract.GetCountryCodeMethod:
-		ip, err := decodeString(action.Data)
-		if err != nil {
-			return fail("getCountryCode: invalid params: " + err.Error())
-		}
-		return success(d.Service.GetCountryCode(ip))
-	case contract.GetMemoryMethod:
-		return success(d.Service.GetMemory())
-	case contract.StartLogMethod:
-		d.Service.StartLog()
-		return success(true)
-	case contract.StopLogMethod:
-		d.Service.StopLog()
-		return success(true)
-	case contract.StartMemoryMethod:
-		d.Service.StartMemory()
-		return success(true)
-	case contract.StopMemoryMethod:
-		d.Service.StopMemory()
-		return success(true)
-	case contract.StartConnectionsMethod:
-		d.Service.StartConnections()
-		return success(true)
-	case contract.StopConnectionsMethod:
-		d.Service.StopConnections()
-		return success(true)
-	case contract.StartListenerMethod:
-		return success(d.Service.StartListener())
-	case contract.StopListenerMethod:
-		return success(d.Service.StopListener())
-	case contract.UpdateDnsMethod:
-		data, err := decodeString(action.Data)
-		if err != nil {
-			return fail("updateDns: invalid params: " + err.Error())
-		}
-		d.Service.UpdateDns(data)
-		return success(true)
-	case contract.CrashMethod:
-		result.AfterSend = d.Service.Crash
-		return success(true)
-	case contract.DeleteFileMethod:
-		path, err := decodeString(action.Data)
-		if err != nil {
-			return fail("deleteFile: invalid params: " + err.Error())
-		}
-		return success(d.Service.DeleteFile(path))
-	default:
-		return fail("unknown method")
-	}
+// unknownMethod is the handler for methods without a route.
+func unknownMethod(_ context.Context, req *Request) (any, error) {
+	return nil, contract.Errorf(contract.ErrUnsupported, "unknown method: %s", req.Action.Method)
 }
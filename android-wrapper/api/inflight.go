@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"mihomo_android_wrapper/contract"
+)
+
+type inflightEntry struct {
+	cancel context.CancelFunc
+}
+
+// inflight tracks running actions by action ID so they can be cancelled.
+type inflight struct {
+	mu      sync.Mutex
+	entries map[string]*inflightEntry
+}
+
+// start derives the action context (applying TimeoutMs) and registers it under the action ID.
+// The returned func must be called when the action completes.
+func (f *inflight) start(parent context.Context, action contract.Action) (context.Context, func()) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if action.TimeoutMs > 0 {
+		ctx, cancel = context.WithTimeout(parent, time.Duration(action.TimeoutMs)*time.Millisecond)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+
+	if action.ID == "" {
+		return ctx, cancel
+	}
+
+	entry := &inflightEntry{cancel: cancel}
+	f.mu.Lock()
+	if f.entries == nil {
+		f.entries = make(map[string]*inflightEntry)
+	}
+	f.entries[action.ID] = entry
+	f.mu.Unlock()
+
+	return ctx, func() {
+		f.mu.Lock()
+		if f.entries[action.ID] == entry {
+			delete(f.entries, action.ID)
+		}
+		f.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel cancels the running action with the given ID; it reports whether one was found.
+func (f *inflight) cancel(id string) bool {
+	f.mu.Lock()
+	entry := f.entries[id]
+	f.mu.Unlock()
+	if entry == nil {
+		return false
+	}
+	entry.cancel()
+	return true
+}
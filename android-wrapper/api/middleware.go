@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"mihomo_android_wrapper/contract"
+)
+
+// Validator is implemented by params types that can check themselves before dispatch.
+type Validator interface {
+	Validate() error
+}
+
+// Recover converts handler panics into ErrInternal responses.
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) (data any, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					data = nil
+					err = contract.Errorf(contract.ErrInternal, "panic recovered: %v", r)
+				}
+			}()
+			return next(ctx, req)
+		}
+	}
+}
+
+// Validate rejects requests whose params implement Validator and fail validation.
+func Validate() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) (any, error) {
+			if v, ok := req.Params.(Validator); ok {
+				if err := v.Validate(); err != nil {
+					if e := contract.AsError(err); e.Code != contract.ErrInternal {
+						return nil, e
+					}
+					return nil, invalidParams(req.Action.Method, err)
+				}
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// Logger reports each dispatched action with its outcome and duration through logf.
+func Logger(logf func(format string, args ...any)) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) (any, error) {
+			start := time.Now()
+			data, err := next(ctx, req)
+			outcome := "ok"
+			if err != nil {
+				outcome = string(contract.AsError(err).Code)
+			}
+			logf("[API] %s id=%s %s in %s", req.Action.Method, req.Action.ID, outcome, time.Since(start))
+			return data, err
+		}
+	}
+}
+
+// AccessControl rejects methods for which allow returns false.
+func AccessControl(allow func(method contract.Method) bool) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) (any, error) {
+			if !allow(req.Action.Method) {
+				return nil, contract.Errorf(contract.ErrPermissionDenied, "method not allowed: %s", req.Action.Method)
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+type MethodStats struct {
+	Calls   int64 `json:"calls"`
+	Errors  int64 `json:"errors"`
+	TotalMs int64 `json:"total-ms"`
+	MaxMs   int64 `json:"max-ms"`
+}
+
+// Metrics collects per-method call counts and timings.
+type Metrics struct {
+	mu    sync.Mutex
+	stats map[contract.Method]*MethodStats
+}
+
+// Middleware returns a Middleware that records into m.
+func (m *Metrics) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) (any, error) {
+			start := time.Now()
+			data, err := next(ctx, req)
+			m.record(req.Action.Method, time.Since(start), err)
+			return data, err
+		}
+	}
+}
+
+// record adds one call of method to the stats.
+func (m *Metrics) record(method contract.Method, elapsed time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stats == nil {
+		m.stats = make(map[contract.Method]*MethodStats)
+	}
+	s := m.stats[method]
+	if s == nil {
+		s = &MethodStats{}
+		m.stats[method] = s
+	}
+	ms := elapsed.Milliseconds()
+	s.Calls++
+	s.TotalMs += ms
+	if ms > s.MaxMs {
+		s.MaxMs = ms
+	}
+	if err != nil {
+		s.Errors++
+	}
+}
+
+// Snapshot returns a copy of the collected stats.
+func (m *Metrics) Snapshot() map[contract.Method]MethodStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[contract.Method]MethodStats, len(m.stats))
+	for method, s := range m.stats {
+		snapshot[method] = *s
+	}
+	return snapshot
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"context"
+
+	"mihomo_android_wrapper/contract"
+)
+
+// registerRoutes registers the handlers for every contract.Method backed by Service.
+func (d *Dispatcher) registerRoutes() {
+	svc := d.Service
+
+	Register(d, contract.InitClashMethod, jsonParams[contract.InitParams], func(_ context.Context, params contract.InitParams) (any, error) {
+		return done(svc.InitClash(params))
+	})
+	Register(d, contract.GetVersionMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return svc.GetVersion(), nil
+	})
+	Register(d, contract.GetIsInitMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return svc.GetIsInit(), nil
+	})
+	Register(d, contract.ForceGcMethod, noParams, func(context.Context, struct{}) (any, error) {
+		svc.ForceGC()
+		return true, nil
+	})
+	Register(d, contract.ShutdownMethod, optionalParams[contract.ShutdownParams], func(ctx context.Context, params contract.ShutdownParams) (any, error) {
+		return svc.Shutdown(ctx, params), nil
+	})
+	Register(d, contract.ValidateConfigMethod, decodeString, func(_ context.Context, path string) (any, error) {
+		return done(svc.ValidateConfig(path))
+	})
+	Register(d, contract.ValidateConfigDiagnosticsMethod, jsonParams[contract.ConfigSourceParams], func(_ context.Context, params contract.ConfigSourceParams) (any, error) {
+		return svc.ValidateConfigDiagnostics(params)
+	})
+	Register(d, contract.GetConfigMethod, decodeString, func(_ context.Context, path string) (any, error) {
+		return svc.GetConfig(path)
+	})
+	Register(d, contract.GetEffectiveConfigMethod, optionalParams[contract.EffectiveConfigParams], func(_ context.Context, params contract.EffectiveConfigParams) (any, error) {
+		return svc.GetEffectiveConfig(params)
+	})
+	Register(d, contract.UpdateConfigMethod, decodeString, func(_ context.Context, payload string) (any, error) {
+		return svc.UpdateConfig(payload)
+	})
+	Register(d, contract.SetupConfigMethod, rawParams, func(ctx context.Context, payload string) (any, error) {
+		return done(svc.SetupConfig(ctx, payload))
+	})
+	Register(d, contract.RollbackConfigMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return done(svc.RollbackConfig())
+	})
+	Register(d, contract.DiffConfigMethod, jsonParams[contract.ConfigSourceParams], func(_ context.Context, params contract.ConfigSourceParams) (any, error) {
+		return svc.DiffConfig(params)
+	})
+	Register(d, contract.GetOverridesMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return svc.GetOverrides()
+	})
+	Register(d, contract.SetOverridesMethod, jsonParams[contract.Overrides], func(_ context.Context, params contract.Overrides) (any, error) {
+		return done(svc.SetOverrides(params))
+	})
+	Register(d, contract.ClearOverridesMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return done(svc.ClearOverrides())
+	})
+	Register(d, contract.ImportShareLinksMethod, decodeString, func(_ context.Context, data string) (any, error) {
+		return svc.ImportShareLinks(data), nil
+	})
+	Register(d, contract.ExportShareLinkMethod, decodeString, func(_ context.Context, name string) (any, error) {
+		return svc.ExportShareLink(name)
+	})
+	Register(d, contract.ListProfilesMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return svc.ListProfiles()
+	})
+	Register(d, contract.CreateProfileMethod, jsonParams[contract.CreateProfileParams], func(_ context.Context, params contract.CreateProfileParams) (any, error) {
+		return svc.CreateProfile(params)
+	})
+	Register(d, contract.ImportProfileMethod, jsonParams[contract.ImportProfileParams], func(_ context.Context, params contract.ImportProfileParams) (any, error) {
+		return svc.ImportProfile(params)
+	})
+	Register(d, contract.RenameProfileMethod, jsonParams[contract.RenameProfileParams], func(_ context.Context, params contract.RenameProfileParams) (any, error) {
+		return done(svc.RenameProfile(params))
+	})
+	Register(d, contract.DeleteProfileMethod, decodeString, func(_ context.Context, id string) (any, error) {
+		return done(svc.DeleteProfile(id))
+	})
+	Register(d, contract.ActivateProfileMethod, jsonParams[contract.ActivateProfileParams], func(ctx context.Context, params contract.ActivateProfileParams) (any, error) {
+		return done(svc.ActivateProfile(ctx, params))
+	})
+	Register(d, contract.DownloadProfileMethod, jsonParams[contract.DownloadProfileParams], func(ctx context.Context, params contract.DownloadProfileParams) (any, error) {
+		return svc.DownloadProfile(ctx, params)
+	})
+	Register(d, contract.GetProxiesMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return svc.GetProxies(), nil
+	})
+	Register(d, contract.ChangeProxyMethod, jsonParams[contract.ChangeProxyParams], func(_ context.Context, params contract.ChangeProxyParams) (any, error) {
+		return done(svc.ChangeProxy(params))
+	})
+	Register(d, contract.GetSelectionsMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return svc.GetSelections()
+	})
+	Register(d, contract.GetTrafficMethod, decodeBool, func(_ context.Context, onlyProxy bool) (any, error) {
+		return svc.GetTraffic(onlyProxy), nil
+	})
+	Register(d, contract.GetTotalTrafficMethod, decodeBool, func(_ context.Context, onlyProxy bool) (any, error) {
+		return svc.GetTotalTraffic(onlyProxy), nil
+	})
+	Register(d, contract.ResetTrafficMethod, noParams, func(context.Context, struct{}) (any, error) {
+		svc.ResetTraffic()
+		return true, nil
+	})
+	Register(d, contract.AsyncTestDelayMethod, decodeString, func(ctx context.Context, payload string) (any, error) {
+		return svc.AsyncTestDelay(ctx, payload)
+	})
+	Register(d, contract.TestGroupDelayMethod, jsonParams[contract.TestGroupDelayParams], func(ctx context.Context, params contract.TestGroupDelayParams) (any, error) {
+		return svc.TestGroupDelay(ctx, params)
+	})
+	Register(d, contract.GetDelayHistoryMethod, optionalParams[contract.DelayHistoryParams], func(_ context.Context, params contract.DelayHistoryParams) (any, error) {
+		return svc.GetDelayHistory(params)
+	})
+	Register(d, contract.DiagnoseProxyMethod, jsonParams[contract.DiagnoseProxyParams], func(ctx context.Context, params contract.DiagnoseProxyParams) (any, error) {
+		return svc.DiagnoseProxy(ctx, params)
+	})
+	Register(d, contract.TestThroughputMethod, jsonParams[contract.TestThroughputParams], func(ctx context.Context, params contract.TestThroughputParams) (any, error) {
+		return svc.TestThroughput(ctx, params)
+	})
+	Register(d, contract.GetConnectionsMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return svc.GetConnections(), nil
+	})
+	Register(d, contract.CloseConnectionsMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return svc.CloseConnections(), nil
+	})
+	Register(d, contract.ResetConnectionsMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return svc.ResetConnections(), nil
+	})
+	Register(d, contract.CloseConnectionMethod, decodeString, func(_ context.Context, id string) (any, error) {
+		return done(svc.CloseConnection(id))
+	})
+	Register(d, contract.GetExternalProvidersMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return svc.GetExternalProviders()
+	})
+	Register(d, contract.GetExternalProviderMethod, decodeString, func(_ context.Context, name string) (any, error) {
+		return svc.GetExternalProvider(name)
+	})
+	Register(d, contract.UpdateGeoDataMethod, decodeString, func(ctx context.Context, payload string) (any, error) {
+		return done(svc.UpdateGeoData(ctx, payload))
+	})
+	Register(d, contract.SideLoadExternalProviderMethod, decodeString, func(_ context.Context, payload string) (any, error) {
+		return done(svc.SideLoadExternalProvider(payload))
+	})
+	Register(d, contract.UpdateExternalProviderMethod, decodeString, func(ctx context.Context, name string) (any, error) {
+		return done(svc.UpdateExternalProvider(ctx, name))
+	})
+	Register(d, contract.GetCountryCodeMethod, decodeString, func(_ context.Context, ip string) (any, error) {
+		return svc.GetCountryCode(ip)
+	})
+	Register(d, contract.GetMemoryMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return svc.GetMemory(), nil
+	})
+	Register(d, contract.StartLogMethod, noParams, func(context.Context, struct{}) (any, error) {
+		svc.StartLog()
+		return true, nil
+	})
+	Register(d, contract.StopLogMethod, noParams, func(context.Context, struct{}) (any, error) {
+		svc.StopLog()
+		return true, nil
+	})
+	Register(d, contract.StartMemoryMethod, noParams, func(context.Context, struct{}) (any, error) {
+		svc.StartMemory()
+		return true, nil
+	})
+	Register(d, contract.StopMemoryMethod, noParams, func(context.Context, struct{}) (any, error) {
+		svc.StopMemory()
+		return true, nil
+	})
+	Register(d, contract.StartConnectionsMethod, noParams, func(context.Context, struct{}) (any, error) {
+		svc.StartConnections()
+		return true, nil
+	})
+	Register(d, contract.StopConnectionsMethod, noParams, func(context.Context, struct{}) (any, error) {
+		svc.StopConnections()
+		return true, nil
+	})
+	Register(d, contract.StartListenerMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return done(svc.StartListener())
+	})
+	Register(d, contract.StopListenerMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return done(svc.StopListener())
+	})
+	Register(d, contract.UpdateDnsMethod, decodeString, func(_ context.Context, value string) (any, error) {
+		svc.UpdateDns(value)
+		return true, nil
+	})
+	d.Handle(contract.CrashMethod, untyped(noParams), func(_ context.Context, req *Request) (any, error) {
+		req.AfterSend = svc.Crash
+		return true, nil
+	})
+	Register(d, contract.DeleteFileMethod, decodeString, func(_ context.Context, path string) (any, error) {
+		return done(svc.DeleteFile(path))
+	})
+	Register(d, contract.GetStateMethod, noParams, func(context.Context, struct{}) (any, error) {
+		return svc.GetState(), nil
+	})
+	Register(d, contract.GetCapabilitiesMethod, noParams, func(context.Context, struct{}) (any, error) {
+		capabilities := svc.GetCapabilities()
+		capabilities.Methods = d.Methods()
+		return capabilities, nil
+	})
+	Register(d, contract.CancelActionMethod, decodeString, func(_ context.Context, id string) (any, error) {
+		if !d.inflight.cancel(id) {
+			return nil, contract.NewError(contract.ErrNotFound, "action not found").
+				WithDetails(map[string]string{"id": id})
+		}
+		return true, nil
+	})
+	d.Handle(contract.BatchMethod, untyped(jsonParams[contract.BatchParams]), func(ctx context.Context, req *Request) (any, error) {
+		responses, afterSend, err := d.dispatchBatch(ctx, req.Params.(contract.BatchParams))
+		if err != nil {
+			return nil, err
+		}
+		req.AfterSend = afterSend
+		return responses, nil
+	})
+}
+
+// done converts an error-only service result into handler output (true on success).
+func done(err error) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+	return true, nil
+}
@@ -1,51 +1,85 @@
 package contract
 
-import "encoding/json"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
 
 type Method string
 
 const (
 	MessageMethod Method = "message"
 
-	InitClashMethod                Method = "initClash"
-	GetVersionMethod               Method = "getVersion"
-	GetIsInitMethod                Method = "getIsInit"
-	ForceGcMethod                  Method = "forceGc"
-	ShutdownMethod                 Method = "shutdown"
-	ValidateConfigMethod           Method = "validateConfig"
-	GetConfigMethod                Method = "getConfig"
-	UpdateConfigMethod             Method = "updateConfig"
-	SetupConfigMethod              Method = "setupConfig"
-	GetProxiesMethod               Method = "getProxies"
-	ChangeProxyMethod              Method = "changeProxy"
-	GetTrafficMethod               Method = "getTraffic"
-	GetTotalTrafficMethod          Method = "getTotalTraffic"
-	ResetTrafficMethod             Method = "resetTraffic"
-	AsyncTestDelayMethod           Method = "asyncTestDelay"
-	GetConnectionsMethod           Method = "getConnections"
-	CloseConnectionsMethod         Method = "closeConnections"
-	ResetConnectionsMethod         Method = "resetConnections"
-	CloseConnectionMethod          Method = "closeConnection"
-	GetExternalProvidersMethod     Method = "getExternalProviders"
-	GetExternalProviderMethod      Method = "getExternalProvider"
-	UpdateGeoDataMethod            Method = "updateGeoData"
-	SideLoadExternalProviderMethod Method = "sideLoadExternalProvider"
-	UpdateExternalProviderMethod   Method = "updateExternalProvider"
-	GetCountryCodeMethod           Method = "getCountryCode"
-	GetMemoryMethod                Method = "getMemory"
-	StartLogMethod                 Method = "startLog"
-	StopLogMethod                  Method = "stopLog"
-	StartMemoryMethod              Method = "startMemory"
-	StopMemoryMethod               Method = "stopMemory"
-	StartConnectionsMethod         Method = "startConnections"
-	StopConnectionsMethod          Method = "stopConnections"
-	StartListenerMethod            Method = "startListener"
-	StopListenerMethod             Method = "stopListener"
-	UpdateDnsMethod                Method = "updateDns"
-	CrashMethod                    Method = "crash"
-	DeleteFileMethod               Method = "deleteFile"
+	InitClashMethod                 Method = "initClash"
+	GetVersionMethod                Method = "getVersion"
+	GetIsInitMethod                 Method = "getIsInit"
+	ForceGcMethod                   Method = "forceGc"
+	ShutdownMethod                  Method = "shutdown"
+	ValidateConfigMethod            Method = "validateConfig"
+	GetConfigMethod                 Method = "getConfig"
+	UpdateConfigMethod              Method = "updateConfig"
+	SetupConfigMethod               Method = "setupConfig"
+	GetProxiesMethod                Method = "getProxies"
+	ChangeProxyMethod               Method = "changeProxy"
+	GetTrafficMethod                Method = "getTraffic"
+	GetTotalTrafficMethod           Method = "getTotalTraffic"
+	ResetTrafficMethod              Method = "resetTraffic"
+	AsyncTestDelayMethod            Method = "asyncTestDelay"
+	GetConnectionsMethod            Method = "getConnections"
+	CloseConnectionsMethod          Method = "closeConnections"
+	ResetConnectionsMethod          Method = "resetConnections"
+	CloseConnectionMethod           Method = "closeConnection"
+	GetExternalProvidersMethod      Method = "getExternalProviders"
+	GetExternalProviderMethod       Method = "getExternalProvider"
+	UpdateGeoDataMethod             Method = "updateGeoData"
+	SideLoadExternalProviderMethod  Method = "sideLoadExternalProvider"
+	UpdateExternalProviderMethod    Method = "updateExternalProvider"
+	GetCountryCodeMethod            Method = "getCountryCode"
+	GetMemoryMethod                 Method = "getMemory"
+	StartLogMethod                  Method = "startLog"
+	StopLogMethod                   Method = "stopLog"
+	StartMemoryMethod               Method = "startMemory"
+	StopMemoryMethod                Method = "stopMemory"
+	StartConnectionsMethod          Method = "startConnections"
+	StopConnectionsMethod           Method = "stopConnections"
+	StartListenerMethod             Method = "startListener"
+	StopListenerMethod              Method = "stopListener"
+	UpdateDnsMethod                 Method = "updateDns"
+	CrashMethod                     Method = "crash"
+	DeleteFileMethod                Method = "deleteFile"
+	GetCapabilitiesMethod           Method = "getCapabilities"
+	CancelActionMethod              Method = "cancelAction"
+	BatchMethod                     Method = "batch"
+	GetStateMethod                  Method = "getState"
+	RollbackConfigMethod            Method = "rollbackConfig"
+	ValidateConfigDiagnosticsMethod Method = "validateConfigDiagnostics"
+	DiffConfigMethod                Method = "diffConfig"
+	GetOverridesMethod              Method = "getOverrides"
+	SetOverridesMethod              Method = "setOverrides"
+	ClearOverridesMethod            Method = "clearOverrides"
+	GetEffectiveConfigMethod        Method = "getEffectiveConfig"
+	ImportShareLinksMethod          Method = "importShareLinks"
+	ExportShareLinkMethod           Method = "exportShareLink"
+	ListProfilesMethod              Method = "listProfiles"
+	CreateProfileMethod             Method = "createProfile"
+	ImportProfileMethod             Method = "importProfile"
+	RenameProfileMethod             Method = "renameProfile"
+	DeleteProfileMethod             Method = "deleteProfile"
+	ActivateProfileMethod           Method = "activateProfile"
+	DownloadProfileMethod           Method = "downloadProfile"
+	GetSelectionsMethod             Method = "getSelections"
+	TestGroupDelayMethod            Method = "testGroupDelay"
+	GetDelayHistoryMethod           Method = "getDelayHistory"
+	DiagnoseProxyMethod             Method = "diagnoseProxy"
+	TestThroughputMethod            Method = "testThroughput"
 )
 
+// ProtocolVersion identifies the invokeAction contract revision; it is bumped on incompatible changes.
+const ProtocolVersion = 1
+
 type MessageType string
 
 const (
@@ -54,12 +88,90 @@ const (
 	RequestMessage     MessageType = "request"
 	MemoryMessage      MessageType = "memory"
 	ConnectionsMessage MessageType = "connections"
+	StateMessage       MessageType = "state"
+	RollbackMessage    MessageType = "rollback"
+	ProfileMessage     MessageType = "profile"
+	ThroughputMessage  MessageType = "throughput"
 )
 
+// ErrorCode is a stable, machine-readable failure reason carried in Error.Code.
+type ErrorCode string
+
+const (
+	ErrNotInitialized ErrorCode = "NOT_INITIALIZED"
+	ErrInvalidParams  ErrorCode = "INVALID_PARAMS"
+	ErrNotFound       ErrorCode = "NOT_FOUND"
+	ErrConfigParse    ErrorCode = "CONFIG_PARSE"
+	ErrIO             ErrorCode = "IO"
+	ErrUnsupported    ErrorCode = "UNSUPPORTED"
+	ErrInternal       ErrorCode = "INTERNAL"
+
+	ErrCancelled        ErrorCode = "CANCELLED"
+	ErrDeadlineExceeded ErrorCode = "DEADLINE_EXCEEDED"
+	ErrPermissionDenied ErrorCode = "PERMISSION_DENIED"
+	ErrInvalidState     ErrorCode = "INVALID_STATE"
+	ErrProbeFailed      ErrorCode = "PROBE_FAILED"
+)
+
+// Error is the data payload of a failed Response (code=-1).
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	Details any       `json:"details,omitempty"`
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// NewError creates an Error with the given code and message.
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Errorf creates an Error with the given code and a formatted message.
+func Errorf(code ErrorCode, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// WrapError creates an Error with the given code from err.
+func WrapError(code ErrorCode, err error) *Error {
+	return &Error{Code: code, Message: err.Error()}
+}
+
+// WithDetails returns a copy of e carrying details.
+func (e *Error) WithDetails(details any) *Error {
+	clone := *e
+	clone.Details = details
+	return &clone
+}
+
+// AsError converts err into an *Error. Context errors map to ErrCancelled/ErrDeadlineExceeded;
+// other errors without a code are reported as ErrInternal.
+func AsError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return WrapError(ErrCancelled, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return WrapError(ErrDeadlineExceeded, err)
+	}
+	return WrapError(ErrInternal, err)
+}
+
 type Action struct {
 	ID     string          `json:"id"`
 	Method Method          `json:"method"`
 	Data   json.RawMessage `json:"data"`
+	// TimeoutMs bounds the action run time; 0 means no deadline.
+	TimeoutMs int64 `json:"timeout-ms,omitempty"`
 }
 
 type Response struct {
@@ -79,49 +191,735 @@ type InitParams struct {
 	Version int    `json:"version"`
 }
 
+type ShutdownParams struct {
+	// GracePeriodMs is how long open connections may drain before they are closed; 0 closes them immediately.
+	GracePeriodMs int64 `json:"grace-period-ms"`
+}
+
+// ShutdownSummary reports what shutdown stopped.
+type ShutdownSummary struct {
+	ConnectionsDrained  int      `json:"connections-drained"`
+	ConnectionsClosed   int      `json:"connections-closed"`
+	TasksCancelled      int      `json:"tasks-cancelled"`
+	TasksAbandoned      int      `json:"tasks-abandoned"`
+	StreamsStopped      []string `json:"streams-stopped"`
+	GoroutinesRemaining int      `json:"goroutines-remaining"`
+	DurationMs          int64    `json:"duration-ms"`
+}
+
+type BatchParams struct {
+	Actions     []Action `json:"actions"`
+	Parallel    bool     `json:"parallel"`
+	StopOnError bool     `json:"stop-on-error"`
+}
+
+// Validate rejects nested batches, and stop-on-error in parallel mode: every action has started by the
+// first failure, so the batch could not keep the others from taking effect.
+func (p BatchParams) Validate() error {
+	if p.Parallel && p.StopOnError {
+		return NewError(ErrInvalidParams, "batch: stop-on-error is not supported with parallel")
+	}
+	for _, action := range p.Actions {
+		if action.Method == BatchMethod {
+			return NewError(ErrInvalidParams, "batch: nested batch is not supported")
+		}
+	}
+	return nil
+}
+
+type Capabilities struct {
+	ProtocolVersion int             `json:"protocol-version"`
+	Methods         []Method        `json:"methods"`
+	MessageTypes    []MessageType   `json:"message-types"`
+	BuildTags       []string        `json:"build-tags"`
+	GoVersion       string          `json:"go-version"`
+	MihomoVersion   string          `json:"mihomo-version"`
+	Features        map[string]bool `json:"features"`
+}
+
+// Validate checks that home-dir is set.
+func (p InitParams) Validate() error {
+	if p.HomeDir == "" {
+		return NewError(ErrInvalidParams, "missing home-dir")
+	}
+	return nil
+}
+
 type ChangeProxyParams struct {
 	GroupName string `json:"group-name"`
 	ProxyName string `json:"proxy-name"`
 }
 
+// Validate checks that group-name is set.
+func (p ChangeProxyParams) Validate() error {
+	if p.GroupName == "" {
+		return NewError(ErrInvalidParams, "missing group-name")
+	}
+	return nil
+}
+
+// MaxDelaySamples bounds the number of requests a delay test sends per proxy.
+const MaxDelaySamples = 10
+
+// Delay test failure reasons, reported in DelayStats.Reason.
+const (
+	DelayFailureDNS              = "dns"
+	DelayFailureConnect          = "connect"
+	DelayFailureTLS              = "tls"
+	DelayFailureTimeout          = "timeout"
+	DelayFailureUnexpectedStatus = "unexpected-status"
+)
+
+// TestGroupDelayParams selects the proxies testGroupDelay tests: the members of GroupName, or ProxyNames.
+type TestGroupDelayParams struct {
+	GroupName  string   `json:"group-name"`
+	ProxyNames []string `json:"proxy-names"`
+	TestURL    string   `json:"test-url"`
+	// Timeout is the per-request timeout in milliseconds (default 5000).
+	Timeout int64 `json:"timeout"`
+	// Concurrency bounds how many proxies are tested at once (default 10).
+	Concurrency int `json:"concurrency"`
+	// ExpectedStatus lists the accepted status codes in mihomo's syntax, e.g. "204" or "200/300-399";
+	// any status is accepted when it is empty.
+	ExpectedStatus string `json:"expected-status"`
+	// Method is the request method, HEAD (default) or GET.
+	Method string `json:"method"`
+	// Samples is the number of requests per proxy (default 1, at most MaxDelaySamples).
+	Samples int `json:"samples"`
+}
+
+// Validate checks that exactly one of group-name and proxy-names is set and that limits are not negative.
+func (p TestGroupDelayParams) Validate() error {
+	if p.GroupName == "" && len(p.ProxyNames) == 0 {
+		return NewError(ErrInvalidParams, "missing group-name or proxy-names")
+	}
+	if p.GroupName != "" && len(p.ProxyNames) > 0 {
+		return NewError(ErrInvalidParams, "group-name and proxy-names are mutually exclusive")
+	}
+	if p.Timeout < 0 {
+		return NewError(ErrInvalidParams, "timeout must not be negative")
+	}
+	if p.Concurrency < 0 {
+		return NewError(ErrInvalidParams, "concurrency must not be negative")
+	}
+	if p.Samples < 0 || p.Samples > MaxDelaySamples {
+		return Errorf(ErrInvalidParams, "samples must be between 0 and %d", MaxDelaySamples)
+	}
+	return nil
+}
+
+// DelayStats summarizes the samples of one delay test. Delays are in milliseconds over the
+// successful samples; Jitter is the mean difference between consecutive successful samples.
+type DelayStats struct {
+	Min     int32 `json:"min"`
+	Avg     int32 `json:"avg"`
+	Max     int32 `json:"max"`
+	Jitter  int32 `json:"jitter"`
+	Samples int   `json:"samples"`
+	Failed  int   `json:"failed"`
+	// Reason and Error describe the last failed sample; Reason is one of the DelayFailure values.
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Status is the last HTTP status received, if any.
+	Status int `json:"status,omitempty"`
+}
+
+// ProxyDelay is the data of a DelayMessage and one testGroupDelay result. It has the fields of the
+// asyncTestDelay result; Value is the average delay, or -1 when every sample failed.
+type ProxyDelay struct {
+	// Group is the tested group; it is empty when proxy-names were given.
+	Group string `json:"group,omitempty"`
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Value int32  `json:"value"`
+	DelayStats
+}
+
+// DiagnoseProxyParams selects the proxy diagnoseProxy checks and the request of its http stage.
+type DiagnoseProxyParams struct {
+	ProxyName      string `json:"proxy-name"`
+	TestURL        string `json:"test-url"`
+	ExpectedStatus string `json:"expected-status"`
+	// Timeout bounds each stage in milliseconds (default 5000).
+	Timeout int64 `json:"timeout"`
+}
+
+// Validate checks that proxy-name is set and timeout is not negative.
+func (p DiagnoseProxyParams) Validate() error {
+	if p.ProxyName == "" {
+		return NewError(ErrInvalidParams, "missing proxy-name")
+	}
+	if p.Timeout < 0 {
+		return NewError(ErrInvalidParams, "timeout must not be negative")
+	}
+	return nil
+}
+
+// Diagnosis stages, in the order diagnoseProxy runs them.
+const (
+	// DiagnosisResolve resolves the proxy server's host with the core resolver.
+	DiagnosisResolve = "resolve"
+	// DiagnosisConnect opens a TCP connection to the proxy server.
+	DiagnosisConnect = "connect"
+	// DiagnosisTLS runs a TLS handshake with the proxy server's TLS settings.
+	DiagnosisTLS = "tls"
+	// DiagnosisHandshake dials the test URL through the proxy, including its protocol handshake.
+	DiagnosisHandshake = "handshake"
+	// DiagnosisHTTP sends the test request over the proxied connection.
+	DiagnosisHTTP = "http"
+)
+
+// Statuses of a DiagnosisStage.
+const (
+	StageOK      = "ok"
+	StageFailed  = "failed"
+	StageSkipped = "skipped"
+)
+
+// DiagnosisStage is the outcome of one diagnoseProxy stage. Detail describes what the stage found
+// or why it was skipped; Error is the exact error of a failed stage.
+type DiagnosisStage struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	DurationMs int64  `json:"duration-ms"`
+	Detail     string `json:"detail,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ProxyDiagnosis is the diagnoseProxy result. Stages after a failed one are skipped.
+type ProxyDiagnosis struct {
+	Name        string           `json:"name"`
+	Type        string           `json:"type"`
+	Server      string           `json:"server,omitempty"`
+	URL         string           `json:"url"`
+	OK          bool             `json:"ok"`
+	FailedStage string           `json:"failed-stage,omitempty"`
+	Stages      []DiagnosisStage `json:"stages"`
+}
+
+// MaxThroughputDuration is the longest testThroughput direction, in milliseconds.
+const MaxThroughputDuration = 60000
+
+// TestThroughputParams selects the proxy testThroughput measures and bounds each direction.
+type TestThroughputParams struct {
+	ProxyName string `json:"proxy-name"`
+	// DownloadURL is fetched with GET. UploadURL, when set, receives a POST of generated data.
+	DownloadURL string `json:"download-url"`
+	UploadURL   string `json:"upload-url"`
+	// Duration bounds each direction in milliseconds, connecting included (default 10000).
+	Duration int64 `json:"duration"`
+	// MaxBytes bounds the data moved in each direction (default 100 MiB).
+	MaxBytes int64 `json:"max-bytes"`
+}
+
+// Validate checks that proxy-name and download-url are set and the limits are in range.
+func (p TestThroughputParams) Validate() error {
+	if p.ProxyName == "" {
+		return NewError(ErrInvalidParams, "missing proxy-name")
+	}
+	if p.DownloadURL == "" {
+		return NewError(ErrInvalidParams, "missing download-url")
+	}
+	if p.Duration < 0 || p.Duration > MaxThroughputDuration {
+		return Errorf(ErrInvalidParams, "duration must be between 0 and %d", MaxThroughputDuration)
+	}
+	if p.MaxBytes < 0 {
+		return NewError(ErrInvalidParams, "max-bytes must not be negative")
+	}
+	return nil
+}
+
+// Directions of a throughput measurement.
+const (
+	ThroughputDownload = "download"
+	ThroughputUpload   = "upload"
+)
+
+// Reasons a throughput measurement stopped.
+const (
+	// ThroughputStopEnd means the download body ended, or the upload server answered early.
+	ThroughputStopEnd = "end"
+	// ThroughputStopDuration means the duration ran out.
+	ThroughputStopDuration = "duration"
+	// ThroughputStopMaxBytes means max-bytes were moved.
+	ThroughputStopMaxBytes = "max-bytes"
+	// ThroughputStopError means the transfer failed; the stats carry the error.
+	ThroughputStopError = "error"
+)
+
+// ThroughputProgress is the data of a ThroughputMessage, emitted periodically while data moves.
+type ThroughputProgress struct {
+	Name           string `json:"name"`
+	Direction      string `json:"direction"`
+	Bytes          int64  `json:"bytes"`
+	ElapsedMs      int64  `json:"elapsed-ms"`
+	BytesPerSecond int64  `json:"bytes-per-second"`
+}
+
+// ThroughputStats is the outcome of one direction. DurationMs is the transfer time, which starts with
+// the response headers of a download and with the request of an upload.
+type ThroughputStats struct {
+	URL            string `json:"url"`
+	Bytes          int64  `json:"bytes"`
+	DurationMs     int64  `json:"duration-ms"`
+	BytesPerSecond int64  `json:"bytes-per-second"`
+	// Stopped is one of the ThroughputStop values.
+	Stopped string `json:"stopped"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ThroughputResult is the testThroughput result; Upload is present when an upload-url was given.
+type ThroughputResult struct {
+	Name     string           `json:"name"`
+	Download ThroughputStats  `json:"download"`
+	Upload   *ThroughputStats `json:"upload,omitempty"`
+}
+
+// Sources of a DelayRecord.
+const (
+	// DelaySourceTest marks a sample of asyncTestDelay or testGroupDelay.
+	DelaySourceTest = "test"
+	// DelaySourceHealthCheck marks a result of mihomo's own URL tests: provider health checks,
+	// url-test and fallback groups, and external controller delay requests.
+	DelaySourceHealthCheck = "health-check"
+)
+
+// DelayRecord is one delay result kept in a proxy's history.
+type DelayRecord struct {
+	// Time is a Unix time in milliseconds.
+	Time int64 `json:"time"`
+	// Delay is in milliseconds, or -1 for a failure.
+	Delay  int32  `json:"delay"`
+	URL    string `json:"url"`
+	Source string `json:"source"`
+}
+
+// DelayHistoryParams selects the proxies getDelayHistory reports; ProxyNames empty means every proxy
+// with records. Records adds the records themselves.
+type DelayHistoryParams struct {
+	ProxyNames []string `json:"proxy-names"`
+	Records    bool     `json:"records"`
+}
+
+// ProxyDelayHistory summarizes the delay history of a proxy. Delay statistics cover the successful
+// records and are 0 without any.
+type ProxyDelayHistory struct {
+	Count  int `json:"count"`
+	Failed int `json:"failed"`
+	// LossRate is Failed/Count.
+	LossRate float64 `json:"loss-rate"`
+	Min      int32   `json:"min"`
+	P50      int32   `json:"p50"`
+	P90      int32   `json:"p90"`
+	P99      int32   `json:"p99"`
+	Max      int32   `json:"max"`
+	// LastDelay is the delay of the newest record; LastSuccess is the time of the newest successful one.
+	LastDelay   int32         `json:"last-delay"`
+	LastSuccess int64         `json:"last-success"`
+	Records     []DelayRecord `json:"records,omitempty"`
+}
+
+// GroupDelaySummary is the testGroupDelay result; Results are in group or proxy-names order.
+type GroupDelaySummary struct {
+	Group      string       `json:"group,omitempty"`
+	URL        string       `json:"url"`
+	Tested     int          `json:"tested"`
+	Alive      int          `json:"alive"`
+	Failed     int          `json:"failed"`
+	DurationMs int64        `json:"duration-ms"`
+	Results    []ProxyDelay `json:"results"`
+}
+
+// State is the lifecycle state of the core.
+type State string
+
+const (
+	// StateUninitialized is the state before initClash and after shutdown.
+	StateUninitialized State = "uninitialized"
+	// StateInitialized means the home dir is set but no config has been applied.
+	StateInitialized State = "initialized"
+	// StateRunning means a config is applied and inbound listeners are up.
+	StateRunning State = "running"
+	// StateListenersStopped means a config is applied but inbound listeners were stopped via stopListener.
+	StateListenersStopped State = "listeners-stopped"
+	// StateSuspended means the tunnel is suspended via suspend(true).
+	StateSuspended State = "suspended"
+	// StateShuttingDown is entered while shutdown is in progress.
+	StateShuttingDown State = "shutting-down"
+)
+
+// StateInfo is the getState result and the data of a StateMessage.
+type StateInfo struct {
+	State    State  `json:"state"`
+	Previous State  `json:"previous,omitempty"`
+	TunUp    bool   `json:"tun-up"`
+	Reason   string `json:"reason,omitempty"`
+	// Since is the Unix time in milliseconds of the last transition.
+	Since int64 `json:"since"`
+}
+
+// ConfigSourceParams selects a config by file path or inline payload; payload takes precedence.
+type ConfigSourceParams struct {
+	ConfigPath string `json:"config-path"`
+	Payload    string `json:"payload"`
+}
+
+// Validate checks that config-path or payload is set.
+func (p ConfigSourceParams) Validate() error {
+	if p.ConfigPath == "" && p.Payload == "" {
+		return NewError(ErrInvalidParams, "missing config-path or payload")
+	}
+	return nil
+}
+
+// DiagnosticSeverity is the severity of a ConfigDiagnostic; only errors make a config invalid.
+type DiagnosticSeverity string
+
+const (
+	DiagnosticError   DiagnosticSeverity = "error"
+	DiagnosticWarning DiagnosticSeverity = "warning"
+)
+
+// ConfigDiagnostic is one finding of validateConfigDiagnostics.
+// Path is a YAML path like "proxy-groups[3].proxies[1]"; Line and Column are 1-based, or 0 when unknown.
+type ConfigDiagnostic struct {
+	Severity DiagnosticSeverity `json:"severity"`
+	Path     string             `json:"path"`
+	Line     int                `json:"line"`
+	Column   int                `json:"column"`
+	Message  string             `json:"message"`
+}
+
+// ConfigValidation is the validateConfigDiagnostics result; Valid is false if any diagnostic is an error.
+type ConfigValidation struct {
+	Valid       bool               `json:"valid"`
+	Diagnostics []ConfigDiagnostic `json:"diagnostics"`
+}
+
+// NamedDiff lists the entries of a named config section (proxies, groups, providers) that differ.
+type NamedDiff struct {
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+// RulesDiff lists added and removed rules; Reordered is set when the rules both configs share are in a different order.
+type RulesDiff struct {
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Reordered bool     `json:"reordered"`
+}
+
+// SettingChange is one changed setting, keyed by its dotted YAML path; Old or New is null when the setting is absent.
+type SettingChange struct {
+	Key string `json:"key"`
+	Old any    `json:"old"`
+	New any    `json:"new"`
+}
+
+// ConfigDiff is the diffConfig result: what applying the candidate config would change.
+// Base is the source of the applied config ("payload" or a file path), or empty when none is applied.
+type ConfigDiff struct {
+	Base           string          `json:"base"`
+	Changed        bool            `json:"changed"`
+	Proxies        NamedDiff       `json:"proxies"`
+	ProxyGroups    NamedDiff       `json:"proxy-groups"`
+	Rules          RulesDiff       `json:"rules"`
+	ProxyProviders NamedDiff       `json:"proxy-providers"`
+	RuleProviders  NamedDiff       `json:"rule-providers"`
+	DNS            []SettingChange `json:"dns"`
+	General        []SettingChange `json:"general"`
+}
+
+// ConfigUpdate is the updateConfig result: the top-level config sections whose values changed, sorted.
+type ConfigUpdate struct {
+	Changed []string `json:"changed"`
+}
+
+type EffectiveConfigParams struct {
+	// Redact replaces passwords, UUIDs, keys, subscription URLs and the controller secret; nil means true.
+	Redact *bool `json:"redact"`
+}
+
+// EffectiveConfig is the config the core is running: the applied raw config with host overrides,
+// updateConfig patches and mihomo defaults, and runtime settings and selector choices on top.
+type EffectiveConfig struct {
+	Source   string            `json:"source"`
+	Redacted bool              `json:"redacted"`
+	Config   map[string]any    `json:"config"`
+	Selected map[string]string `json:"selected"`
+}
+
+// Overrides is the host overlay merged into every config the core parses (setupConfig, updateConfig,
+// startListener, rollbacks). It is stored under the home dir and survives profile switches and restarts.
+type Overrides struct {
+	// Patch is a JSON merge patch (RFC 7396) applied to the profile; a null value removes the key.
+	Patch map[string]any `json:"patch"`
+	// PrependRules are inserted before the profile's rules.
+	PrependRules []string `json:"prepend-rules"`
+}
+
+// Validate rejects empty prepended rules.
+func (o Overrides) Validate() error {
+	for i, rule := range o.PrependRules {
+		if rule == "" {
+			return Errorf(ErrInvalidParams, "empty rule at prepend-rules[%d]", i)
+		}
+	}
+	return nil
+}
+
+// ShareLinkError is a line of an importShareLinks body that could not be converted.
+// Line is 1-based in the decoded body.
+type ShareLinkError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// ShareLinkImport is the importShareLinks result: mihomo proxy maps ready for a config's proxies
+// section, and the lines that could not be converted.
+type ShareLinkImport struct {
+	Proxies []map[string]any `json:"proxies"`
+	Errors  []ShareLinkError `json:"errors"`
+}
+
+// Profile is a config stored by the core under <home-dir>/profiles.
+type Profile struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Path is the profile's config file.
+	Path string `json:"path"`
+	// CreatedAt and UpdatedAt are Unix times in milliseconds; UpdatedAt changes when the config content is written.
+	CreatedAt int64 `json:"created-at"`
+	UpdatedAt int64 `json:"updated-at"`
+	// Selected holds the selector choices recorded for the profile, which activateProfile restores.
+	// It is filled in results only; the core keeps the choices in selections.json.
+	Selected map[string]string `json:"selected,omitempty"`
+	// Remote is set for profiles created by downloadProfile.
+	Remote *RemoteProfile `json:"remote,omitempty"`
+}
+
+// RemoteProfile is where a downloaded profile comes from and what the last download returned.
+type RemoteProfile struct {
+	URL       string            `json:"url"`
+	UserAgent string            `json:"user-agent,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	// Proxy is the proxy or group the profile is fetched through; empty fetches directly.
+	Proxy string `json:"proxy,omitempty"`
+	// MaxSize is the largest accepted body in bytes; 0 means the default of 10 MiB.
+	MaxSize int64 `json:"max-size,omitempty"`
+	// RefreshInterval re-fetches the profile in the background every this many seconds; 0 disables it.
+	RefreshInterval int64 `json:"refresh-interval,omitempty"`
+
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last-modified,omitempty"`
+	// FetchedAt is the Unix time in milliseconds of the last successful download.
+	FetchedAt    int64             `json:"fetched-at"`
+	Subscription *SubscriptionInfo `json:"subscription,omitempty"`
+}
+
+// SubscriptionInfo is the parsed subscription-userinfo response header: traffic in bytes, and the
+// expiry as Unix time in seconds (0 when absent).
+type SubscriptionInfo struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+	Total    int64 `json:"total"`
+	Expire   int64 `json:"expire"`
+}
+
+// ProfileList is the listProfiles result; Active is the id of the profile the running config was
+// loaded from, or empty.
+type ProfileList struct {
+	Active   string    `json:"active"`
+	Profiles []Profile `json:"profiles"`
+}
+
+type CreateProfileParams struct {
+	Name string `json:"name"`
+	// Payload is the config content.
+	Payload string `json:"payload"`
+}
+
+// Validate checks that name and payload are set.
+func (p CreateProfileParams) Validate() error {
+	if p.Name == "" {
+		return NewError(ErrInvalidParams, "missing name")
+	}
+	if p.Payload == "" {
+		return NewError(ErrInvalidParams, "missing payload")
+	}
+	return nil
+}
+
+type ImportProfileParams struct {
+	Name string `json:"name"`
+	// ConfigPath is the config file to copy into the profile store.
+	ConfigPath string `json:"config-path"`
+}
+
+// Validate checks that name and config-path are set.
+func (p ImportProfileParams) Validate() error {
+	if p.Name == "" {
+		return NewError(ErrInvalidParams, "missing name")
+	}
+	if p.ConfigPath == "" {
+		return NewError(ErrInvalidParams, "missing config-path")
+	}
+	return nil
+}
+
+type RenameProfileParams struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Validate checks that id and name are set.
+func (p RenameProfileParams) Validate() error {
+	if p.ID == "" {
+		return NewError(ErrInvalidParams, "missing id")
+	}
+	if p.Name == "" {
+		return NewError(ErrInvalidParams, "missing name")
+	}
+	return nil
+}
+
+// ActivateProfileParams selects the profile to apply; the probe fields work as in setupConfig.
+type ActivateProfileParams struct {
+	ID           string `json:"id"`
+	TestURL      string `json:"test-url"`
+	Probe        bool   `json:"probe"`
+	ProbeTimeout int64  `json:"probe-timeout"`
+}
+
+// Validate checks that id is set.
+func (p ActivateProfileParams) Validate() error {
+	if p.ID == "" {
+		return NewError(ErrInvalidParams, "missing id")
+	}
+	return nil
+}
+
+// DownloadProfileParams downloads a profile from URL into a new profile, or, with ID, refreshes an
+// existing one. Set fields replace the options stored with the profile.
+type DownloadProfileParams struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	URL       string            `json:"url"`
+	UserAgent string            `json:"user-agent"`
+	Headers   map[string]string `json:"headers"`
+	Proxy     string            `json:"proxy"`
+	MaxSize   int64             `json:"max-size"`
+	// RefreshInterval is in seconds; 0 disables background refresh and nil keeps the stored value.
+	RefreshInterval *int64 `json:"refresh-interval"`
+}
+
+// Validate checks that a new profile has an http(s) URL and that sizes and intervals are not negative.
+func (p DownloadProfileParams) Validate() error {
+	if p.ID == "" && p.URL == "" {
+		return NewError(ErrInvalidParams, "missing url")
+	}
+	if p.URL != "" && !strings.HasPrefix(p.URL, "http://") && !strings.HasPrefix(p.URL, "https://") {
+		return Errorf(ErrInvalidParams, "url %q is not an http or https URL", p.URL)
+	}
+	if p.MaxSize < 0 {
+		return NewError(ErrInvalidParams, "max-size must not be negative")
+	}
+	if p.RefreshInterval != nil && *p.RefreshInterval < 0 {
+		return NewError(ErrInvalidParams, "refresh-interval must not be negative")
+	}
+	return nil
+}
+
+// ProfileDownload is the downloadProfile result; Changed is false when the server reported the
+// profile unmodified or returned the stored content.
+type ProfileDownload struct {
+	Profile Profile `json:"profile"`
+	Changed bool    `json:"changed"`
+}
+
+// ProfileRefresh is the data of a ProfileMessage, emitted when a background refresh changed a
+// profile or failed.
+type ProfileRefresh struct {
+	ID      string `json:"id"`
+	Changed bool   `json:"changed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RollbackInfo is the data of a RollbackMessage.
+type RollbackInfo struct {
+	// Reason is "probe-failed" or "requested".
+	Reason string `json:"reason"`
+	// Error describes the probe failure, if any.
+	Error string `json:"error,omitempty"`
+	// Source is the config path, or "payload", of the restored config.
+	Source string `json:"source"`
+}
+
 type Emitter interface {
 	Emit(message Message)
 }
 
 type Service interface {
-	InitClash(params InitParams) bool
+	InitClash(params InitParams) error
 	GetVersion() string
+	GetCapabilities() Capabilities
 	GetIsInit() bool
+	GetState() StateInfo
 	ForceGC()
-	Shutdown() bool
+	Shutdown(ctx context.Context, params ShutdownParams) ShutdownSummary
 
-	ValidateConfig(path string) string
+	ValidateConfig(path string) error
+	ValidateConfigDiagnostics(params ConfigSourceParams) (ConfigValidation, error)
 	GetConfig(path string) (any, error)
+	GetEffectiveConfig(params EffectiveConfigParams) (EffectiveConfig, error)
+
+	UpdateConfig(payload string) (ConfigUpdate, error)
+	SetupConfig(ctx context.Context, payload string) error
+	RollbackConfig() error
+	DiffConfig(params ConfigSourceParams) (ConfigDiff, error)
+
+	GetOverrides() (Overrides, error)
+	SetOverrides(params Overrides) error
+	ClearOverrides() error
+
+	ImportShareLinks(data string) ShareLinkImport
+	ExportShareLink(name string) (string, error)
 
-	UpdateConfig(payload string) string
-	SetupConfig(payload string) string
+	ListProfiles() (ProfileList, error)
+	CreateProfile(params CreateProfileParams) (Profile, error)
+	ImportProfile(params ImportProfileParams) (Profile, error)
+	RenameProfile(params RenameProfileParams) error
+	DeleteProfile(id string) error
+	ActivateProfile(ctx context.Context, params ActivateProfileParams) error
+	DownloadProfile(ctx context.Context, params DownloadProfileParams) (ProfileDownload, error)
 
 	GetProxies() any
-	ChangeProxy(params ChangeProxyParams) string
+	ChangeProxy(params ChangeProxyParams) error
+	GetSelections() (map[string]string, error)
 
 	GetTraffic(onlyProxy bool) string
 	GetTotalTraffic(onlyProxy bool) string
 	ResetTraffic()
 
-	AsyncTestDelay(payload string) string
+	AsyncTestDelay(ctx context.Context, payload string) (string, error)
+	TestGroupDelay(ctx context.Context, params TestGroupDelayParams) (GroupDelaySummary, error)
+	GetDelayHistory(params DelayHistoryParams) (map[string]ProxyDelayHistory, error)
+	DiagnoseProxy(ctx context.Context, params DiagnoseProxyParams) (ProxyDiagnosis, error)
+	TestThroughput(ctx context.Context, params TestThroughputParams) (ThroughputResult, error)
 
 	GetConnections() string
 	CloseConnections() bool
 	ResetConnections() bool
-	CloseConnection(id string) bool
+	CloseConnection(id string) error
 
-	GetExternalProviders() string
-	GetExternalProvider(name string) string
-	UpdateGeoData(payload string) string
-	SideLoadExternalProvider(payload string) string
-	UpdateExternalProvider(providerName string) string
+	GetExternalProviders() (string, error)
+	GetExternalProvider(name string) (string, error)
+	UpdateGeoData(ctx context.Context, payload string) error
+	SideLoadExternalProvider(payload string) error
+	UpdateExternalProvider(ctx context.Context, providerName string) error
 
-	GetCountryCode(ip string) string
+	GetCountryCode(ip string) (string, error)
 	GetMemory() string
 
 	StartLog()
@@ -133,12 +931,14 @@ type Service interface {
 	StartConnections()
 	StopConnections()
 
-	StartListener() bool
-	StopListener() bool
+	StartListener() error
+	StopListener() error
 
 	UpdateDns(value string)
 	Suspend(suspended bool) bool
+	// NotifyTun reports that the host-owned TUN device was started or stopped.
+	NotifyTun(up bool)
 
 	Crash()
-	DeleteFile(path string) string
+	DeleteFile(path string) error
 }
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"runtime"
+
+	"mihomo_android_wrapper/contract"
+)
+
+// buildTags lists the optional build tags compiled into this library; see tags_*.go.
+var buildTags []string
+
+// emittedMessageTypes lists the message types the core may emit through the Emitter.
+var emittedMessageTypes = []contract.MessageType{
+	contract.LogMessage,
+	contract.DelayMessage,
+	contract.MemoryMessage,
+	contract.ConnectionsMessage,
+	contract.StateMessage,
+	contract.RollbackMessage,
+	contract.ProfileMessage,
+	contract.ThroughputMessage,
+}
+
+// hasBuildTag reports whether tag was set when building the library.
+func hasBuildTag(tag string) bool {
+	for _, t := range buildTags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// handleGetCapabilities describes the protocol, build and features of this library.
+// Methods is filled in by the dispatcher, which owns method routing.
+func handleGetCapabilities() contract.Capabilities {
+	return contract.Capabilities{
+		ProtocolVersion: contract.ProtocolVersion,
+		MessageTypes:    append([]contract.MessageType(nil), emittedMessageTypes...),
+		BuildTags:       append([]string{}, buildTags...),
+		GoVersion:       runtime.Version(),
+		MihomoVersion:   handleGetVersion(),
+		Features: map[string]bool{
+			"structured-errors": true,
+			"system-dns-update": dnsUpdater != nil,
+			"gvisor-stack":      hasBuildTag("with_gvisor"),
+			"graceful-shutdown": true,
+		},
+	}
+}
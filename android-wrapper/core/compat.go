@@ -1,18 +1,19 @@
-//go:build android && cgo
-
 package core
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net"
 	"os"
 	"strconv"
 
+	"mihomo_android_wrapper/contract"
+
 	"github.com/metacubex/mihomo/adapter/provider"
 	"github.com/metacubex/mihomo/component/mmdb"
-	"github.com/metacubex/mihomo/component/updater"
 	"github.com/metacubex/mihomo/config"
+	"github.com/metacubex/mihomo/constant"
 	cp "github.com/metacubex/mihomo/constant/provider"
 	rp "github.com/metacubex/mihomo/rules/provider"
 	"github.com/metacubex/mihomo/tunnel/statistic"
@@ -22,63 +23,61 @@ import (
 func handleGetConfig(path string) (*config.RawConfig, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, err
+		return nil, fileError(err, path)
+	}
+	raw, err := config.UnmarshalRawConfig(data)
+	if err != nil {
+		return nil, contract.WrapError(contract.ErrConfigParse, err)
 	}
-	return config.UnmarshalRawConfig(data)
+	return raw, nil
 }
 
 // handleGetExternalProvider returns a JSON description of the given external provider.
-func handleGetExternalProvider(providerName string) string {
+func handleGetExternalProvider(providerName string) (string, error) {
 	coreMu.Lock()
 	defer coreMu.Unlock()
 
 	p := getExternalProvidersRaw()[providerName]
 	if p == nil {
-		return ""
+		return "", errExternalProviderNotFound(providerName)
 	}
 
 	ep, err := toExternalProvider(p)
 	if err != nil {
-		return ""
+		return "", contract.WrapError(contract.ErrUnsupported, err)
 	}
 
 	data, err := json.Marshal(ep)
 	if err != nil {
-		return ""
+		return "", contract.WrapError(contract.ErrInternal, err)
 	}
-	return string(data)
+	return string(data), nil
 }
 
 // handleUpdateGeoData updates Geo databases (GeoIP/GeoSite/MMDB/ASN) based on payload.
-func handleUpdateGeoData(payload string) string {
+// Cancelling ctx aborts the download; a cancelled update never replaces the database on disk.
+func handleUpdateGeoData(ctx context.Context, payload string) error {
+	ctx, done, err := beginTask(ctx, initializedStates...)
+	if err != nil {
+		return err
+	}
+	defer done()
+
 	var params map[string]string
 	if err := json.Unmarshal([]byte(payload), &params); err != nil {
-		return err.Error()
+		return invalidParams(err)
 	}
 
 	geoType := params["geo-type"]
 	if geoType == "" {
-		return "missing geo-type"
-	}
-
-	var err error
-	switch geoType {
-	case "MMDB":
-		err = updater.UpdateMMDB()
-	case "ASN":
-		err = updater.UpdateASN()
-	case "GEOIP":
-		err = updater.UpdateGeoIp()
-	case "GEOSITE":
-		err = updater.UpdateGeoSite()
-	default:
-		err = errors.New("unknown geo-type")
+		return missingParam("geo-type")
 	}
 
-	if err != nil {
-		return err.Error()
+	db := geoDatabaseFor(geoType)
+	if db == nil {
+		return contract.Errorf(contract.ErrInvalidParams, "unknown geo-type: %s", geoType)
 	}
-	return ""
+	return updateGeoDatabase(ctx, db)
 }
 
 // sideUpdateExternalProvider performs SideUpdate on an external provider.
@@ -96,37 +95,46 @@ func sideUpdateExternalProvider(p cp.Provider, data []byte) error {
 }
 
 // handleSideLoadExternalProvider side-loads data into an external provider.
-func handleSideLoadExternalProvider(payload string) string {
+func handleSideLoadExternalProvider(payload string) error {
+	if err := requireState(configuredStates...); err != nil {
+		return err
+	}
+
 	var params map[string]string
 	if err := json.Unmarshal([]byte(payload), &params); err != nil {
-		return err.Error()
+		return invalidParams(err)
 	}
 
 	providerName := params["provider-name"]
 	if providerName == "" {
-		return "missing provider-name"
+		return missingParam("provider-name")
 	}
 
 	p := getExternalProvidersRaw()[providerName]
 	if p == nil {
-		return "external provider not found"
+		return errExternalProviderNotFound(providerName)
 	}
 
 	data := []byte(params["data"])
 	if err := sideUpdateExternalProvider(p, data); err != nil {
-		return err.Error()
+		return contract.WrapError(contract.ErrConfigParse, err)
 	}
 
-	return ""
+	return nil
 }
 
 // handleGetCountryCode looks up the country/region code for an IP using MMDB.
-func handleGetCountryCode(ip string) string {
+// A missing MMDB file is reported as ErrNotFound, since mihomo exits the process when it cannot load one.
+func handleGetCountryCode(ip string) (string, error) {
+	path := constant.Path.MMDB()
+	if _, err := os.Stat(path); err != nil {
+		return "", fileError(err, path)
+	}
 	codes := mmdb.IPInstance().LookupCode(net.ParseIP(ip))
 	if len(codes) == 0 {
-		return ""
+		return "", nil
 	}
-	return codes[0]
+	return codes[0], nil
 }
 
 // handleGetMemory returns current memory usage as a decimal string.
@@ -140,22 +148,22 @@ func handleCrash() {
 }
 
 // handleDeleteFile deletes a file or directory (missing path is treated as success).
-func handleDeleteFile(path string) string {
+func handleDeleteFile(path string) error {
 	info, err := os.Stat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return ""
+			return nil
 		}
-		return err.Error()
+		return fileError(err, path)
 	}
 	if info.IsDir() {
 		if err := os.RemoveAll(path); err != nil {
-			return err.Error()
+			return fileError(err, path)
 		}
-		return ""
+		return nil
 	}
 	if err := os.Remove(path); err != nil {
-		return err.Error()
+		return fileError(err, path)
 	}
-	return ""
+	return nil
 }
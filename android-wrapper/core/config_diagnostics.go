@@ -0,0 +1,420 @@
+package core
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+// builtinProxies are outbound names that exist without being declared in the config.
+var builtinProxies = map[string]bool{
+	"DIRECT":      true,
+	"REJECT":      true,
+	"REJECT-DROP": true,
+	"PASS":        true,
+	"COMPATIBLE":  true,
+	"GLOBAL":      true,
+}
+
+var (
+	yamlLinePattern     = regexp.MustCompile(`line (\d+)`)
+	yamlUnmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	rawConfigType       = reflect.TypeOf(config.RawConfig{})
+)
+
+// diagnostics collects ConfigDiagnostic entries.
+type diagnostics struct {
+	list []contract.ConfigDiagnostic
+}
+
+// add records a diagnostic positioned at node (which may be nil).
+func (d *diagnostics) add(severity contract.DiagnosticSeverity, path string, node *yaml.Node, format string, args ...any) {
+	diagnostic := contract.ConfigDiagnostic{
+		Severity: severity,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	if node != nil {
+		diagnostic.Line = node.Line
+		diagnostic.Column = node.Column
+	}
+	d.list = append(d.list, diagnostic)
+}
+
+// hasErrors reports whether any diagnostic is an error.
+func (d *diagnostics) hasErrors() bool {
+	for _, diagnostic := range d.list {
+		if diagnostic.Severity == contract.DiagnosticError {
+			return true
+		}
+	}
+	return false
+}
+
+// handleValidateConfigDiagnostics validates a config file or payload without applying it and
+// returns every problem found, positioned in the YAML source.
+func handleValidateConfigDiagnostics(params contract.ConfigSourceParams) (contract.ConfigValidation, error) {
+	data, err := readConfigSource(params)
+	if err != nil {
+		return contract.ConfigValidation{}, err
+	}
+
+	d := diagnoseConfig(data)
+	return contract.ConfigValidation{
+		Valid:       !d.hasErrors(),
+		Diagnostics: d.list,
+	}, nil
+}
+
+// diagnoseConfig runs the YAML, schema and reference checks, then mihomo's own parser if they found no error.
+func diagnoseConfig(data []byte) *diagnostics {
+	d := &diagnostics{list: []contract.ConfigDiagnostic{}}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		addYAMLError(d, err)
+		return d
+	}
+	if len(root.Content) == 0 {
+		d.add(contract.DiagnosticError, "", nil, "config is empty")
+		return d
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		d.add(contract.DiagnosticError, "", doc, "config must be a YAML mapping")
+		return d
+	}
+
+	checkDuplicateKeys(d, doc, "")
+	checkKeys(d, doc, rawConfigType, "")
+	checkReferences(d, doc)
+
+	if !d.hasErrors() {
+		if _, err := config.Parse(data); err != nil {
+			d.add(contract.DiagnosticError, "", nil, "%s", err.Error())
+		}
+	}
+
+	// Positioned diagnostics first, in source order; Line 0 means no position.
+	sort.SliceStable(d.list, func(i, j int) bool {
+		li, lj := d.list[i].Line, d.list[j].Line
+		if li == 0 || lj == 0 {
+			return lj == 0 && li != 0
+		}
+		if li != lj {
+			return li < lj
+		}
+		return d.list[i].Column < d.list[j].Column
+	})
+	return d
+}
+
+// addYAMLError converts a YAML syntax or type error into diagnostics, one per reported line.
+func addYAMLError(d *diagnostics, err error) {
+	for _, message := range strings.Split(err.Error(), "\n") {
+		message = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(message), "yaml:"))
+		if message == "" || strings.HasPrefix(message, "unmarshal errors:") {
+			continue
+		}
+		diagnostic := contract.ConfigDiagnostic{Severity: contract.DiagnosticError, Message: message}
+		if match := yamlLinePattern.FindStringSubmatch(message); match != nil {
+			diagnostic.Line, _ = strconv.Atoi(match[1])
+		}
+		d.list = append(d.list, diagnostic)
+	}
+}
+
+// checkDuplicateKeys reports repeated keys in every mapping under node.
+func checkDuplicateKeys(d *diagnostics, node *yaml.Node, path string) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		seen := make(map[string]*yaml.Node)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := joinPath(path, key.Value)
+			if first, ok := seen[key.Value]; ok && key.Value != "<<" {
+				d.add(contract.DiagnosticError, keyPath, key, "duplicate key %q (first defined at line %d)", key.Value, first.Line)
+			} else {
+				seen[key.Value] = key
+			}
+			checkDuplicateKeys(d, value, keyPath)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			checkDuplicateKeys(d, item, indexPath(path, i))
+		}
+	}
+}
+
+// checkKeys warns about mapping keys that t (a mihomo Raw* config struct) does not declare.
+func checkKeys(d *diagnostics, node *yaml.Node, t reflect.Type, path string) {
+	fields := yamlFields(t)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Value == "<<" {
+			continue
+		}
+		keyPath := joinPath(path, key.Value)
+		fieldType, ok := fields[key.Value]
+		if !ok {
+			d.add(contract.DiagnosticWarning, keyPath, key, "unknown key %q", key.Value)
+			continue
+		}
+		for fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		value = resolve(value)
+		if fieldType.Kind() == reflect.Struct && value.Kind == yaml.MappingNode && !customUnmarshal(fieldType) {
+			checkKeys(d, value, fieldType, keyPath)
+		}
+	}
+}
+
+// yamlFields maps the YAML keys of struct t to field types, following inline fields.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		name := tag[0]
+		if name == "-" {
+			continue
+		}
+		if len(tag) > 1 && tag[1] == "inline" && field.Type.Kind() == reflect.Struct {
+			for key, fieldType := range yamlFields(field.Type) {
+				fields[key] = fieldType
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// customUnmarshal reports whether t decodes itself, so its YAML shape may differ from its fields.
+func customUnmarshal(t reflect.Type) bool {
+	ptr := reflect.PointerTo(t)
+	return ptr.Implements(yamlUnmarshalerType) || ptr.Implements(textUnmarshalerType)
+}
+
+// checkReferences validates proxy, group and provider names and the references between them.
+func checkReferences(d *diagnostics, doc *yaml.Node) {
+	declared := make(map[string]*yaml.Node)
+	declare := func(kind string, items []*yaml.Node, section string) []string {
+		var names []string
+		for i, item := range items {
+			itemPath := indexPath(section, i)
+			nameNode := lookup(item, "name")
+			if nameNode == nil || nameNode.Value == "" {
+				d.add(contract.DiagnosticError, itemPath, item, "%s has no name", kind)
+				continue
+			}
+			name := nameNode.Value
+			names = append(names, name)
+			if first, ok := declared[name]; ok {
+				d.add(contract.DiagnosticError, joinPath(itemPath, "name"), nameNode, "duplicate name %q (first defined at line %d)", name, first.Line)
+				continue
+			}
+			if builtinProxies[name] {
+				d.add(contract.DiagnosticError, joinPath(itemPath, "name"), nameNode, "name %q is reserved", name)
+				continue
+			}
+			declared[name] = nameNode
+		}
+		return names
+	}
+
+	declare("proxy", items(lookup(doc, "proxies")), "proxies")
+	groups := items(lookup(doc, "proxy-groups"))
+	groupNames := declare("proxy group", groups, "proxy-groups")
+	proxyProviders := keys(lookup(doc, "proxy-providers"))
+	ruleProviders := keys(lookup(doc, "rule-providers"))
+
+	exists := func(name string) bool {
+		return declared[name] != nil || builtinProxies[name]
+	}
+
+	members := make(map[string][]string)
+	for i, group := range groups {
+		groupPath := indexPath("proxy-groups", i)
+		var name string
+		if nameNode := lookup(group, "name"); nameNode != nil {
+			name = nameNode.Value
+		}
+		for j, item := range items(lookup(group, "proxies")) {
+			members[name] = append(members[name], item.Value)
+			if !exists(item.Value) {
+				d.add(contract.DiagnosticError, indexPath(joinPath(groupPath, "proxies"), j), item, "proxy %q not found", item.Value)
+			}
+		}
+		for j, item := range items(lookup(group, "use")) {
+			if !proxyProviders[item.Value] {
+				d.add(contract.DiagnosticError, indexPath(joinPath(groupPath, "use"), j), item, "proxy provider %q not found", item.Value)
+			}
+		}
+	}
+
+	targets := checkRules(d, items(lookup(doc, "rules")), "rules", exists, ruleProviders)
+	if subRules := resolve(lookup(doc, "sub-rules")); subRules != nil && subRules.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(subRules.Content); i += 2 {
+			section := joinPath("sub-rules", subRules.Content[i].Value)
+			targets = append(targets, checkRules(d, items(subRules.Content[i+1]), section, exists, ruleProviders)...)
+		}
+	}
+
+	// In global and direct mode rules are not used, so every group is reachable from the GLOBAL selector.
+	mode := ""
+	if modeNode := lookup(doc, "mode"); modeNode != nil {
+		mode = strings.ToLower(modeNode.Value)
+	}
+	if mode != "" && mode != "rule" {
+		return
+	}
+	reachable := make(map[string]bool)
+	for len(targets) > 0 {
+		name := targets[0]
+		targets = targets[1:]
+		if reachable[name] {
+			continue
+		}
+		reachable[name] = true
+		targets = append(targets, members[name]...)
+	}
+	for i, name := range groupNames {
+		if !reachable[name] {
+			nameNode := lookup(groups[i], "name")
+			d.add(contract.DiagnosticWarning, joinPath(indexPath("proxy-groups", i), "name"), nameNode, "proxy group %q is not used by any rule or group", name)
+		}
+	}
+}
+
+// checkRules validates rule targets and RULE-SET providers, returning the targets found.
+func checkRules(d *diagnostics, rules []*yaml.Node, section string, exists func(string) bool, ruleProviders map[string]bool) []string {
+	var targets []string
+	for i, rule := range rules {
+		rulePath := indexPath(section, i)
+		fields := strings.Split(rule.Value, ",")
+		for j := range fields {
+			fields[j] = strings.TrimSpace(fields[j])
+		}
+
+		targetIndex := 2
+		switch strings.ToUpper(fields[0]) {
+		case "AND", "OR", "NOT":
+			// Logic rules nest commas inside parentheses; the target is the last field.
+			targets = append(targets, fields[len(fields)-1])
+			continue
+		case "SUB-RULE":
+			continue
+		case "MATCH":
+			targetIndex = 1
+		case "RULE-SET":
+			if len(fields) > 1 && !ruleProviders[fields[1]] {
+				d.add(contract.DiagnosticError, rulePath, rule, "rule provider %q not found", fields[1])
+			}
+		}
+
+		if len(fields) <= targetIndex || fields[targetIndex] == "" {
+			d.add(contract.DiagnosticError, rulePath, rule, "rule %q has no target", rule.Value)
+			continue
+		}
+		target := fields[targetIndex]
+		targets = append(targets, target)
+		if !exists(target) {
+			d.add(contract.DiagnosticError, rulePath, rule, "rule target %q not found", target)
+		}
+	}
+	return targets
+}
+
+// resolve follows YAML aliases.
+func resolve(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// lookup returns the value of key in mapping node, following aliases and "<<" merge keys.
+func lookup(node *yaml.Node, key string) *yaml.Node {
+	node = resolve(node)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	var merges []*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		switch node.Content[i].Value {
+		case key:
+			return resolve(node.Content[i+1])
+		case "<<":
+			merges = append(merges, node.Content[i+1])
+		}
+	}
+	for _, merge := range merges {
+		merge = resolve(merge)
+		candidates := []*yaml.Node{merge}
+		if merge.Kind == yaml.SequenceNode {
+			candidates = merge.Content
+		}
+		for _, candidate := range candidates {
+			if value := lookup(candidate, key); value != nil {
+				return value
+			}
+		}
+	}
+	return nil
+}
+
+// items returns the elements of a sequence node (aliases resolved), or nil.
+func items(node *yaml.Node) []*yaml.Node {
+	node = resolve(node)
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+	result := make([]*yaml.Node, len(node.Content))
+	for i, item := range node.Content {
+		result[i] = resolve(item)
+	}
+	return result
+}
+
+// keys returns the keys of a mapping node.
+func keys(node *yaml.Node) map[string]bool {
+	result := make(map[string]bool)
+	node = resolve(node)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return result
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		result[node.Content[i].Value] = true
+	}
+	return result
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func indexPath(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
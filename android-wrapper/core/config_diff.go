@@ -0,0 +1,270 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/config"
+)
+
+// diffSections are the raw config keys diffed as their own sections; every other key is a general setting.
+// "rule" is the JSON name of RawConfig.Rule.
+var diffSections = map[string]bool{
+	"proxies":         true,
+	"proxy-groups":    true,
+	"rule":            true,
+	"proxy-providers": true,
+	"rule-providers":  true,
+	"dns":             true,
+}
+
+// handleDiffConfig parses a candidate config and reports how it differs from the config applied via
+// setupConfig. Both sides are compared as mihomo raw configs, so defaults are filled in and a key that
+// is only spelled out in one of them is not reported. Without an applied config the candidate is
+// compared against mihomo's defaults.
+func handleDiffConfig(params contract.ConfigSourceParams) (contract.ConfigDiff, error) {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if err := requireState(initializedStates...); err != nil {
+		return contract.ConfigDiff{}, err
+	}
+
+	data, err := readConfigSource(params)
+	if err != nil {
+		return contract.ConfigDiff{}, err
+	}
+	if _, err := parseConfigBytes(data); err != nil {
+		return contract.ConfigDiff{}, err
+	}
+	candidate, err := rawConfigMap(data)
+	if err != nil {
+		return contract.ConfigDiff{}, err
+	}
+
+	var base []byte
+	var diff contract.ConfigDiff
+	if appliedConfig != nil {
+		base = appliedConfig.data
+		diff.Base = appliedConfig.source
+	}
+	applied, err := rawConfigMap(base)
+	if err != nil {
+		return contract.ConfigDiff{}, err
+	}
+
+	diff.Proxies = diffNamedList(applied["proxies"], candidate["proxies"])
+	diff.ProxyGroups = diffNamedList(applied["proxy-groups"], candidate["proxy-groups"])
+	diff.Rules = diffRules(applied["rule"], candidate["rule"])
+	diff.ProxyProviders = diffNamedMap(applied["proxy-providers"], candidate["proxy-providers"])
+	diff.RuleProviders = diffNamedMap(applied["rule-providers"], candidate["rule-providers"])
+	diff.DNS = diffSettings("dns", applied["dns"], candidate["dns"])
+
+	general := func(raw map[string]any) map[string]any {
+		settings := make(map[string]any)
+		for key, value := range raw {
+			if !diffSections[key] {
+				settings[key] = value
+			}
+		}
+		return settings
+	}
+	diff.General = diffSettings("", general(applied), general(candidate))
+
+	diff.Changed = !unchanged(diff.Proxies) || !unchanged(diff.ProxyGroups) ||
+		len(diff.Rules.Added) > 0 || len(diff.Rules.Removed) > 0 || diff.Rules.Reordered ||
+		!unchanged(diff.ProxyProviders) || !unchanged(diff.RuleProviders) ||
+		len(diff.DNS) > 0 || len(diff.General) > 0
+	return diff, nil
+}
+
+// changedSections returns the top-level raw config sections that differ between before and after, sorted.
+func changedSections(before, after []byte) ([]string, error) {
+	old, err := rawConfigMap(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := rawConfigMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := []string{}
+	for key, value := range updated {
+		if !reflect.DeepEqual(old[key], value) {
+			if key == "rule" {
+				key = "rules"
+			}
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// rawConfigMap decodes config content, with the host overrides merged in, into a mihomo RawConfig
+// (defaults included) and returns it as generic JSON values, so both sides of a diff compare with the same types.
+func rawConfigMap(data []byte) (map[string]any, error) {
+	merged, err := mergeOverrides(data, overrides)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := config.UnmarshalRawConfig(merged)
+	if err != nil {
+		return nil, contract.WrapError(contract.ErrConfigParse, err)
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, contract.WrapError(contract.ErrInternal, err)
+	}
+	var generic map[string]any
+	if err := json.Unmarshal(encoded, &generic); err != nil {
+		return nil, contract.WrapError(contract.ErrInternal, err)
+	}
+	return generic, nil
+}
+
+// diffNamedList compares two lists of objects (proxies, proxy groups) by their "name" field.
+// Added and Modified follow the candidate's order, Removed the applied config's.
+func diffNamedList(applied, candidate any) contract.NamedDiff {
+	index := func(list any) ([]string, map[string]any) {
+		items, _ := list.([]any)
+		names := make([]string, 0, len(items))
+		byName := make(map[string]any, len(items))
+		for _, item := range items {
+			entry, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			name := fmt.Sprint(entry["name"])
+			names = append(names, name)
+			byName[name] = entry
+		}
+		return names, byName
+	}
+	appliedNames, appliedByName := index(applied)
+	candidateNames, candidateByName := index(candidate)
+	return diffNamed(appliedNames, appliedByName, candidateNames, candidateByName)
+}
+
+// diffNamedMap compares two objects keyed by name (proxy and rule providers), in name order.
+func diffNamedMap(applied, candidate any) contract.NamedDiff {
+	index := func(value any) ([]string, map[string]any) {
+		byName, _ := value.(map[string]any)
+		names := make([]string, 0, len(byName))
+		for name := range byName {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names, byName
+	}
+	appliedNames, appliedByName := index(applied)
+	candidateNames, candidateByName := index(candidate)
+	return diffNamed(appliedNames, appliedByName, candidateNames, candidateByName)
+}
+
+// diffNamed reports candidate entries missing from or different in applied, then applied entries missing from candidate.
+func diffNamed(appliedNames []string, applied map[string]any, candidateNames []string, candidate map[string]any) contract.NamedDiff {
+	diff := contract.NamedDiff{Added: []string{}, Removed: []string{}, Modified: []string{}}
+	for _, name := range candidateNames {
+		old, ok := applied[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, name)
+		case !reflect.DeepEqual(old, candidate[name]):
+			diff.Modified = append(diff.Modified, name)
+		}
+	}
+	for _, name := range appliedNames {
+		if _, ok := candidate[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	return diff
+}
+
+// diffRules compares rule lists as multisets; rules present in both are then checked for order.
+func diffRules(applied, candidate any) contract.RulesDiff {
+	toStrings := func(value any) []string {
+		items, _ := value.([]any)
+		rules := make([]string, 0, len(items))
+		for _, item := range items {
+			rules = append(rules, fmt.Sprint(item))
+		}
+		return rules
+	}
+	appliedRules, candidateRules := toStrings(applied), toStrings(candidate)
+	diff := contract.RulesDiff{Added: []string{}, Removed: []string{}}
+
+	// keep returns the rules of from that also occur in other (counting duplicates), and the rest.
+	keep := func(from, other []string) (kept, rest []string) {
+		remaining := make(map[string]int)
+		for _, rule := range other {
+			remaining[rule]++
+		}
+		for _, rule := range from {
+			if remaining[rule] > 0 {
+				remaining[rule]--
+				kept = append(kept, rule)
+			} else {
+				rest = append(rest, rule)
+			}
+		}
+		return kept, rest
+	}
+	appliedKept, removed := keep(appliedRules, candidateRules)
+	candidateKept, added := keep(candidateRules, appliedRules)
+	diff.Added = append(diff.Added, added...)
+	diff.Removed = append(diff.Removed, removed...)
+	diff.Reordered = !reflect.DeepEqual(appliedKept, candidateKept)
+	return diff
+}
+
+// diffSettings flattens two setting objects to dotted keys and returns the keys whose values differ, sorted.
+func diffSettings(prefix string, applied, candidate any) []contract.SettingChange {
+	before, after := make(map[string]any), make(map[string]any)
+	flattenSettings(prefix, applied, before)
+	flattenSettings(prefix, candidate, after)
+
+	keys := make([]string, 0, len(before)+len(after))
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := []contract.SettingChange{}
+	for _, key := range keys {
+		if !reflect.DeepEqual(before[key], after[key]) {
+			changes = append(changes, contract.SettingChange{Key: key, Old: before[key], New: after[key]})
+		}
+	}
+	return changes
+}
+
+// flattenSettings stores the leaves of value under dotted keys; lists are compared as a whole.
+func flattenSettings(prefix string, value any, out map[string]any) {
+	object, ok := value.(map[string]any)
+	if !ok || len(object) == 0 {
+		if prefix != "" {
+			out[prefix] = value
+		}
+		return
+	}
+	for key, child := range object {
+		flattenSettings(joinPath(prefix, key), child, out)
+	}
+}
+
+// unchanged reports whether d lists no differences.
+func unchanged(d contract.NamedDiff) bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/common/yaml"
+)
+
+// PatchOperation is one RFC 6902 JSON patch operation against the raw config.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// decodeConfigDocument decodes YAML config content into generic JSON values
+// (map[string]any, []any, float64, ...), the shape JSON merge and JSON patches operate on.
+func decodeConfigDocument(data []byte) (map[string]any, error) {
+	document := make(map[string]any)
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, contract.WrapError(contract.ErrConfigParse, err)
+	}
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return nil, contract.WrapError(contract.ErrConfigParse, err)
+	}
+	normalized := make(map[string]any)
+	if err := json.Unmarshal(encoded, &normalized); err != nil {
+		return nil, contract.WrapError(contract.ErrConfigParse, err)
+	}
+	return normalized, nil
+}
+
+// applyJSONPatch applies ops to document in order; the first failing operation aborts the patch.
+func applyJSONPatch(document map[string]any, ops []PatchOperation) (map[string]any, error) {
+	var doc any = document
+	for i, op := range ops {
+		var err error
+		if doc, err = applyPatchOperation(doc, op); err != nil {
+			return nil, contract.Errorf(contract.ErrInvalidParams, "json-patch[%d]: %s", i, err.Error()).
+				WithDetails(map[string]any{"index": i, "op": op.Op, "path": op.Path})
+		}
+	}
+	result, ok := doc.(map[string]any)
+	if !ok {
+		return nil, contract.NewError(contract.ErrInvalidParams, "json-patch: the config must remain an object")
+	}
+	return result, nil
+}
+
+func applyPatchOperation(doc any, op PatchOperation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	value := func() (any, error) {
+		if op.Value == nil {
+			return nil, errors.New("missing value")
+		}
+		var v any
+		if err := json.Unmarshal(op.Value, &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return addPointer(doc, path, v)
+	case "remove":
+		return removePointer(doc, path)
+	case "replace":
+		v, err := value()
+		if err != nil || len(path) == 0 {
+			return v, err
+		}
+		if doc, err = removePointer(doc, path); err != nil {
+			return nil, err
+		}
+		return addPointer(doc, path, v)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		v, err := getPointer(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if doc, err = removePointer(doc, from); err != nil {
+				return nil, err
+			}
+		} else if v, err = copyValue(v); err != nil {
+			return nil, err
+		}
+		return addPointer(doc, path, v)
+	case "test":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		current, err := getPointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, v) {
+			return nil, errors.New("test failed")
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unknown op %q", op.Op)
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into unescaped reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses an array index token; max is the largest accepted index.
+func arrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return i, nil
+}
+
+func getPointer(node any, path []string) (any, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]any:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("path not found at %q", token)
+			}
+			node = child
+		case []any:
+			i, err := arrayIndex(token, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("cannot traverse into a scalar at %q", token)
+		}
+	}
+	return node, nil
+}
+
+// addPointer adds value at path and returns the updated node; "-" appends to an array.
+func addPointer(node any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token, rest := path[0], path[1:]
+
+	switch n := node.(type) {
+	case map[string]any:
+		if len(rest) == 0 {
+			n[token] = value
+			return n, nil
+		}
+		child, ok := n[token]
+		if !ok {
+			return nil, fmt.Errorf("path not found at %q", token)
+		}
+		updated, err := addPointer(child, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = updated
+		return n, nil
+	case []any:
+		if len(rest) == 0 {
+			i := len(n)
+			if token != "-" {
+				var err error
+				if i, err = arrayIndex(token, len(n)); err != nil {
+					return nil, err
+				}
+			}
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		}
+		i, err := arrayIndex(token, len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := addPointer(n[i], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = updated
+		return n, nil
+	default:
+		return nil, fmt.Errorf("cannot traverse into a scalar at %q", token)
+	}
+}
+
+// removePointer removes the value at path and returns the updated node.
+func removePointer(node any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole config")
+	}
+	token, rest := path[0], path[1:]
+
+	switch n := node.(type) {
+	case map[string]any:
+		child, ok := n[token]
+		if !ok {
+			return nil, fmt.Errorf("path not found at %q", token)
+		}
+		if len(rest) == 0 {
+			delete(n, token)
+			return n, nil
+		}
+		updated, err := removePointer(child, rest)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = updated
+		return n, nil
+	case []any:
+		i, err := arrayIndex(token, len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) == 0 {
+			return append(n[:i], n[i+1:]...), nil
+		}
+		updated, err := removePointer(n[i], rest)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = updated
+		return n, nil
+	default:
+		return nil, fmt.Errorf("cannot traverse into a scalar at %q", token)
+	}
+}
+
+// copyValue deep-copies a generic JSON value.
+func copyValue(v any) (any, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var copied any
+	err = json.Unmarshal(encoded, &copied)
+	return copied, err
+}
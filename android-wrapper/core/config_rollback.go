@@ -0,0 +1,184 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/adapter/outboundgroup"
+	mihomoHttp "github.com/metacubex/mihomo/component/http"
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/log"
+)
+
+// defaultProbeTimeout is used when SetupParams.ProbeTimeout is not set.
+const defaultProbeTimeout = 5 * time.Second
+
+// configSnapshot is a config applied through setupConfig, kept as source bytes so it can be re-parsed.
+type configSnapshot struct {
+	data     []byte
+	source   string
+	selected map[string]string
+	// patches are the updateConfig patches in data, replayed when a config file is re-read.
+	patches []configPatch
+}
+
+// configPatch is the merge patch and JSON patch of one updateConfig call.
+type configPatch struct {
+	merge map[string]any
+	ops   []PatchOperation
+}
+
+// appliedConfig is the config currently applied via setupConfig; lastKnownGood is the one before it.
+// Both are guarded by coreMu.
+var (
+	appliedConfig *configSnapshot
+	lastKnownGood *configSnapshot
+)
+
+// captureAppliedConfig returns the applied config with its current selector choices, or nil.
+func captureAppliedConfig() *configSnapshot {
+	if appliedConfig == nil {
+		return nil
+	}
+	return &configSnapshot{
+		data:     appliedConfig.data,
+		source:   appliedConfig.source,
+		selected: currentSelections(),
+		patches:  appliedConfig.patches,
+	}
+}
+
+// commitAppliedConfig records snapshot as applied and previous as last-known-good. A config file
+// becomes the default config path, so a rejected file never does.
+func commitAppliedConfig(snapshot, previous *configSnapshot) {
+	appliedConfig = snapshot
+	if snapshot.source != "payload" {
+		constant.SetConfig(snapshot.source)
+	}
+	if previous != nil {
+		lastKnownGood = previous
+	}
+}
+
+// currentConfigData returns the content of the applied config, or of the config file if none was recorded.
+func currentConfigData() ([]byte, error) {
+	if appliedConfig != nil {
+		return appliedConfig.data, nil
+	}
+	return readConfigFile(constant.Path.Config())
+}
+
+// resetConfigSnapshots forgets applied configs, e.g. on shutdown.
+func resetConfigSnapshots() {
+	appliedConfig = nil
+	lastKnownGood = nil
+}
+
+// currentSelections returns the current choice of every Selector group.
+func currentSelections() map[string]string {
+	selected := make(map[string]string)
+	for name, proxy := range allProxies() {
+		outbound, ok := proxy.(*adapter.Proxy)
+		if !ok {
+			continue
+		}
+		if selector, ok := outbound.ProxyAdapter.(*outboundgroup.Selector); ok {
+			selected[name] = selector.Now()
+		}
+	}
+	return selected
+}
+
+// restoreConfig re-parses and applies snapshot; a config file becomes the default config path again.
+func restoreConfig(snapshot *configSnapshot) error {
+	cfg, err := parseConfigBytes(snapshot.data)
+	if err != nil {
+		return err
+	}
+	applyConfig(cfg, snapshot.selected)
+	if snapshot.source != "payload" {
+		constant.SetConfig(snapshot.source)
+	}
+	return nil
+}
+
+// probeConnectivity requests testURL through the default route; any HTTP response counts as connected.
+func probeConnectivity(ctx context.Context, testURL string, timeoutMs int64) error {
+	if testURL == "" {
+		testURL = constant.DefaultTestURL
+	}
+	timeout := defaultProbeTimeout
+	if timeoutMs > 0 {
+		timeout = time.Duration(timeoutMs) * time.Millisecond
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	resp, err := mihomoHttp.HttpRequest(probeCtx, testURL, "GET", nil, nil)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+// rollbackAfterProbe restores previous after a failed probe and reports the failure as ErrProbeFailed.
+// Without a previous config the new one stays applied.
+func rollbackAfterProbe(previous *configSnapshot, probeErr error) error {
+	details := map[string]any{"rolled-back": false}
+	if previous == nil {
+		log.Warnln("[Config] connectivity probe failed, no previous config to restore: %s", probeErr.Error())
+		return contract.WrapError(contract.ErrProbeFailed, probeErr).WithDetails(details)
+	}
+
+	if err := restoreConfig(previous); err != nil {
+		log.Errorln("[Config] connectivity probe failed, restoring previous config failed: %s", err.Error())
+		return contract.WrapError(contract.ErrProbeFailed, probeErr).WithDetails(details)
+	}
+	appliedConfig = previous
+
+	log.Warnln("[Config] connectivity probe failed, restored previous config: %s", probeErr.Error())
+	emitMessage(contract.Message{
+		Type: contract.RollbackMessage,
+		Data: contract.RollbackInfo{
+			Reason: "probe-failed",
+			Error:  probeErr.Error(),
+			Source: previous.source,
+		},
+	})
+	details["rolled-back"] = true
+	return contract.WrapError(contract.ErrProbeFailed, probeErr).WithDetails(details)
+}
+
+// handleRollbackConfig restores the last-known-good config; it can be used once per setupConfig.
+func handleRollbackConfig() error {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if err := requireState(configuredStates...); err != nil {
+		return err
+	}
+	if lastKnownGood == nil {
+		return contract.NewError(contract.ErrNotFound, "no last-known-good config")
+	}
+
+	if err := restoreConfig(lastKnownGood); err != nil {
+		return err
+	}
+	appliedConfig = lastKnownGood
+	lastKnownGood = nil
+
+	log.Infoln("[Config] rolled back to previous config")
+	emitMessage(contract.Message{
+		Type: contract.RollbackMessage,
+		Data: contract.RollbackInfo{
+			Reason: "requested",
+			Source: appliedConfig.source,
+		},
+	})
+	return nil
+}
@@ -1,5 +1,3 @@
-//go:build android && cgo
-
 package core
 
 import (
@@ -45,19 +43,20 @@ func handleStartConnections() {
 	}()
 }
 
-// handleStopConnections stops periodic connections reporting.
-func handleStopConnections() {
+// handleStopConnections stops periodic connections reporting; it reports whether the stream was running.
+func handleStopConnections() bool {
 	connectionsMu.Lock()
 	defer connectionsMu.Unlock()
 
 	if connectionsTicker == nil {
-		return
+		return false
 	}
 
 	connectionsTicker.Stop()
 	close(connectionsStopChan)
 	connectionsTicker = nil
 	connectionsStopChan = nil
+	return true
 }
 
 func emitConnectionsData() {
@@ -1,8 +1,7 @@
-//go:build android && cgo
-
 package core
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"path/filepath"
@@ -20,7 +19,6 @@ import (
 	"github.com/metacubex/mihomo/config"
 	"github.com/metacubex/mihomo/constant"
 	"github.com/metacubex/mihomo/hub"
-	"github.com/metacubex/mihomo/hub/executor"
 	"github.com/metacubex/mihomo/log"
 	"github.com/metacubex/mihomo/tunnel"
 	"github.com/metacubex/mihomo/tunnel/statistic"
@@ -28,7 +26,6 @@ import (
 
 var (
 	coreMu sync.Mutex
-	isInit = false
 
 	logSubscriber observable.Subscription[log.Event]
 )
@@ -38,37 +35,53 @@ type SetupParams struct {
 	Payload     string            `json:"payload"`
 	SelectedMap map[string]string `json:"selected-map"`
 	TestURL     string            `json:"test-url"`
+	// Probe requests TestURL through the default route after applying and rolls back on failure.
+	Probe bool `json:"probe"`
+	// ProbeTimeout is the probe timeout in milliseconds (default 5000).
+	ProbeTimeout int64 `json:"probe-timeout"`
 }
 
 // handleInitClash initializes the mihomo runtime and config directory.
-func handleInitClash(params contract.InitParams) bool {
+func handleInitClash(params contract.InitParams) error {
 	coreMu.Lock()
 	defer coreMu.Unlock()
 
 	if params.HomeDir == "" {
 		log.Errorln("[APP] invalid init params: home-dir is empty")
-		return false
+		return missingParam("home-dir")
 	}
 	// params.Version is reserved for Android API-level compatibility handling.
 
-	if !isInit {
+	if currentState() == contract.StateUninitialized {
 		constant.SetHomeDir(params.HomeDir)
 		constant.SetConfig(filepath.Join(params.HomeDir, "config.yaml"))
 		if err := config.Init(params.HomeDir); err != nil {
 			log.Errorln("[APP] failed to init config directory: %s", err.Error())
-			return false
+			return fileError(err, params.HomeDir)
+		}
+		if err := loadOverrides(); err != nil {
+			log.Warnln("[APP] ignoring host overrides: %s", err.Error())
+		}
+		if err := loadProfiles(); err != nil {
+			log.Warnln("[APP] ignoring profile index: %s", err.Error())
+		}
+		if err := loadSelections(); err != nil {
+			log.Warnln("[APP] ignoring recorded selections: %s", err.Error())
+		}
+		if err := loadDelayHistory(); err != nil {
+			log.Warnln("[APP] ignoring delay history: %s", err.Error())
 		}
-		isInit = true
+		startPeriodicSync()
+		enterState(contract.StateInitialized, "initClash")
 	}
 
-	return true
+	return nil
 }
 
 // handleGetIsInit reports whether InitClash has been successfully called.
 func handleGetIsInit() bool {
-	coreMu.Lock()
-	defer coreMu.Unlock()
-	return isInit
+	s := currentState()
+	return s != contract.StateUninitialized && s != contract.StateShuttingDown
 }
 
 // handleGetVersion returns the version string with a leading "v".
@@ -89,81 +102,102 @@ func handleForceGC() {
 	debug.FreeOSMemory()
 }
 
-// handleShutdown stops the VPN TUN, log subscription, and shuts down the mihomo executor.
-func handleShutdown() bool {
-	coreMu.Lock()
-	defer coreMu.Unlock()
-
-	if stopTunHook != nil {
-		stopTunHook()
-	}
-	handleStopLog()
-	executor.Shutdown()
-	isInit = false
-	return true
-}
-
-// handleValidateConfig validates a config file; returns empty string on success.
-func handleValidateConfig(path string) string {
+// handleValidateConfig validates a config file without applying it.
+func handleValidateConfig(path string) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return err.Error()
+		return fileError(err, path)
 	}
-	_, err = config.Parse(data)
-	if err != nil {
-		return err.Error()
+	if _, err = config.Parse(data); err != nil {
+		return contract.WrapError(contract.ErrConfigParse, err)
 	}
-	return ""
+	return nil
 }
 
 // handleSetupConfig loads config and applies the proxy selection mapping.
 // Supports two modes:
 // 1. File mode: params.ConfigPath specifies the config file path
 // 2. Payload mode: params.Payload contains the config content directly
-func handleSetupConfig(data []byte) string {
+// In file mode, the selector choices recorded for the file are restored, with params.SelectedMap taking
+// precedence. The previously applied config becomes the last-known-good one; with params.Probe, a
+// failed connectivity probe restores it before returning ErrProbeFailed.
+func handleSetupConfig(ctx context.Context, data []byte) error {
 	coreMu.Lock()
 	defer coreMu.Unlock()
 
-	if !isInit {
-		return "not initialized"
+	if err := requireState(initializedStates...); err != nil {
+		return err
 	}
 
 	var params SetupParams
 	if err := json.Unmarshal(data, &params); err != nil {
-		return err.Error()
+		return invalidParams(err)
 	}
+	return setupConfig(ctx, params)
+}
 
-	var cfg *config.Config
-	var err error
-
+// setupConfig parses and applies the config selected by params; the caller holds coreMu.
+func setupConfig(ctx context.Context, params SetupParams) error {
+	snapshot := &configSnapshot{selected: params.SelectedMap}
 	if params.Payload != "" {
 		// Payload mode: parse config from memory
-		cfg, err = executor.ParseWithBytes([]byte(params.Payload))
-		if err != nil {
-			return err.Error()
-		}
+		snapshot.data = []byte(params.Payload)
+		snapshot.source = "payload"
 	} else {
-		// File mode: parse config from file
+		// File mode: parse config from file; the config path moves to it once it is committed.
+		path := constant.Path.Config()
 		if params.ConfigPath != "" {
 			if _, err := os.Stat(params.ConfigPath); err != nil {
-				return "config file not found: " + params.ConfigPath
+				return fileError(err, params.ConfigPath)
 			}
-			constant.SetConfig(params.ConfigPath)
+			path = params.ConfigPath
 		}
-		cfg, err = executor.Parse()
+		content, err := readConfigFile(path)
 		if err != nil {
-			return err.Error()
+			return err
 		}
+		snapshot.data = content
+		snapshot.source = path
+		snapshot.selected = storedSelections(snapshot.source, params.SelectedMap)
+	}
+
+	cfg, err := parseConfigBytes(snapshot.data)
+	if err != nil {
+		return err
 	}
 
+	recordSelections()
+	previous := captureAppliedConfig()
+	applyConfig(cfg, snapshot.selected)
+	enterConfiguredState(contract.StateRunning, "setupConfig")
+
+	if params.Probe {
+		if err := probeConnectivity(ctx, params.TestURL, params.ProbeTimeout); err != nil {
+			rollbackErr := rollbackAfterProbe(previous, err)
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return rollbackErr
+		}
+	}
+
+	commitAppliedConfig(snapshot, previous)
+	recordSelections()
+	return nil
+}
+
+// applyConfig applies cfg with mihomo's TUN disabled, then applies host-side selections.
+func applyConfig(cfg *config.Config, selected map[string]string) {
+	// The proxies are replaced, and their mihomo delay results with them.
+	collectDelayHistory()
+
 	// Android provides the VPN fd via startTUN(), so we disable mihomo's built-in TUN.
 	if cfg.General != nil {
 		cfg.General.Tun.Enable = false
 	}
 
 	hub.ApplyConfig(cfg)
-	patchSelectGroup(params.SelectedMap)
-	return ""
+	patchSelectGroup(selected)
 }
 
 // handleGetProxies returns the current proxy list (including providers).
@@ -218,41 +252,44 @@ func patchSelectGroup(mapping map[string]string) {
 	}
 }
 
-// handleChangeProxy updates the selected proxy for a selector group.
-func handleChangeProxy(params contract.ChangeProxyParams) string {
+// handleChangeProxy updates the selected proxy for a selector group and records the new choice.
+func handleChangeProxy(params contract.ChangeProxyParams) error {
 	coreMu.Lock()
 	defer coreMu.Unlock()
 
+	if err := requireState(configuredStates...); err != nil {
+		return err
+	}
 	if params.GroupName == "" {
-		return "missing group-name"
+		return missingParam("group-name")
 	}
 
 	proxies := allProxies()
 	group, ok := proxies[params.GroupName]
 	if !ok {
-		return "group not found"
+		return contract.NewError(contract.ErrNotFound, "group not found").
+			WithDetails(map[string]string{"group-name": params.GroupName})
 	}
 
 	adapterProxy, ok := group.(*adapter.Proxy)
 	if !ok {
-		return "group is not selectable"
+		return contract.NewError(contract.ErrUnsupported, "group is not selectable")
 	}
 
 	selector, ok := adapterProxy.ProxyAdapter.(outboundgroup.SelectAble)
 	if !ok {
-		return "group is not selectable"
+		return contract.NewError(contract.ErrUnsupported, "group is not selectable")
 	}
 
 	if params.ProxyName == "" {
 		selector.ForceSet("")
-		return ""
-	}
-
-	if err := selector.Set(params.ProxyName); err != nil {
-		return err.Error()
+	} else if err := selector.Set(params.ProxyName); err != nil {
+		return contract.WrapError(contract.ErrNotFound, err).
+			WithDetails(map[string]string{"proxy-name": params.ProxyName})
 	}
 
-	return ""
+	recordSelections()
+	return nil
 }
 
 // handleGetTraffic returns a JSON traffic snapshot of current upload/download.
@@ -319,20 +356,37 @@ func handleResetConnections() bool {
 }
 
 // handleCloseConnection closes a single connection by tracker id.
-func handleCloseConnection(id string) bool {
+func handleCloseConnection(id string) error {
 	c := statistic.DefaultManager.Get(id)
 	if c == nil {
-		return false
+		return contract.NewError(contract.ErrNotFound, "connection not found").
+			WithDetails(map[string]string{"id": id})
+	}
+	if err := c.Close(); err != nil {
+		return contract.WrapError(contract.ErrIO, err)
 	}
-	return c.Close() == nil
+	return nil
 }
 
 // handleSuspend toggles mihomo tunnel between suspended and running states.
+// It returns false if no config is applied, or when resuming a core that is not suspended.
 func handleSuspend(suspended bool) bool {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
 	if suspended {
+		if err := requireState(configuredStates...); err != nil {
+			return false
+		}
 		tunnel.OnSuspend()
-	} else {
-		tunnel.OnRunning()
+		enterState(contract.StateSuspended, "suspend")
+		return true
+	}
+
+	if err := requireState(contract.StateSuspended); err != nil {
+		return false
 	}
+	tunnel.OnRunning()
+	enterState(suspendedFrom(), "resume")
 	return true
 }
@@ -1,77 +1,219 @@
-//go:build android && cgo
-
 package core
 
 import (
 	"context"
 	"encoding/json"
+	"sync"
 	"time"
 
-	"github.com/metacubex/mihomo/common/utils"
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/adapter/outboundgroup"
 	"github.com/metacubex/mihomo/constant"
 )
 
+const (
+	// defaultDelayTimeout is the per-test timeout in milliseconds when none is given.
+	defaultDelayTimeout = 5000
+	// defaultDelayConcurrency is used when TestGroupDelayParams.Concurrency is not set.
+	defaultDelayConcurrency = 10
+)
+
 type TestDelayParams struct {
 	ProxyName string `json:"proxy-name"`
 	TestURL   string `json:"test-url"`
 	Timeout   int64  `json:"timeout"`
+	// ExpectedStatus, Method and Samples are as in contract.TestGroupDelayParams.
+	ExpectedStatus string `json:"expected-status"`
+	Method         string `json:"method"`
+	Samples        int    `json:"samples"`
 }
 
 type Delay struct {
 	Url   string `json:"url"`
 	Name  string `json:"name"`
 	Value int32  `json:"value"`
+	contract.DelayStats
 }
 
-// handleAsyncTestDelay runs a URL test for the specified proxy and returns Delay JSON (Value=-1 on failure).
-func handleAsyncTestDelay(paramsString string) string {
+// handleAsyncTestDelay runs a URL test for the specified proxy and returns Delay JSON; Value is the
+// average delay of the samples, or -1 when all of them failed.
+// Cancelling ctx (or shutdown) aborts the test and reports the context error instead of a failed delay.
+func handleAsyncTestDelay(ctx context.Context, paramsString string) (string, error) {
+	if err := requireState(configuredStates...); err != nil {
+		return "", err
+	}
+
 	var params TestDelayParams
 	if err := json.Unmarshal([]byte(paramsString), &params); err != nil {
-		return ""
+		return "", invalidParams(err)
+	}
+
+	opts, err := newDelayOptions(params.TestURL, params.Method, params.ExpectedStatus, params.Timeout, params.Samples)
+	if err != nil {
+		return "", err
+	}
+	delayData := &Delay{
+		Name: params.ProxyName,
+		Url:  opts.url,
 	}
 
-	testURL := params.TestURL
-	if testURL == "" {
-		testURL = constant.DefaultTestURL
+	coreMu.Lock()
+	proxy := allProxies()[params.ProxyName]
+	coreMu.Unlock()
+
+	if proxy == nil {
+		return "", contract.NewError(contract.ErrNotFound, "proxy not found").
+			WithDetails(map[string]string{"proxy-name": params.ProxyName})
 	}
 
-	timeoutMs := params.Timeout
-	if timeoutMs <= 0 {
-		timeoutMs = 5000
+	// Registered after the proxy lookup so a test never waits on coreMu while shutdown waits on it.
+	ctx, done, err := beginTask(ctx, configuredStates...)
+	if err != nil {
+		return "", err
 	}
+	defer done()
 
-	delayData := &Delay{
-		Name: params.ProxyName,
-		Url:  testURL,
+	delayData.Value, delayData.DelayStats = measureDelay(ctx, proxy, opts)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return "", ctxErr
 	}
 
-	expectedStatus, err := utils.NewUnsignedRanges[uint16]("")
+	data, err := json.Marshal(delayData)
 	if err != nil {
-		delayData.Value = -1
-		data, _ := json.Marshal(delayData)
-		return string(data)
+		return "", contract.WrapError(contract.ErrInternal, err)
+	}
+	return string(data), nil
+}
+
+// handleTestGroupDelay tests the members of a group, or a list of proxies, with at most
+// params.Concurrency tests running at once. Each result is emitted as a DelayMessage when it arrives;
+// the summary is returned once every proxy was tested. Cancelling ctx (or shutdown) stops the
+// remaining tests and reports the context error.
+func handleTestGroupDelay(ctx context.Context, params contract.TestGroupDelayParams) (contract.GroupDelaySummary, error) {
+	if err := requireState(configuredStates...); err != nil {
+		return contract.GroupDelaySummary{}, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutMs)*time.Millisecond)
-	defer cancel()
+	opts, err := newDelayOptions(params.TestURL, params.Method, params.ExpectedStatus, params.Timeout, params.Samples)
+	if err != nil {
+		return contract.GroupDelaySummary{}, err
+	}
 
 	coreMu.Lock()
-	proxy := allProxies()[params.ProxyName]
+	targets, err := delayTargets(params)
 	coreMu.Unlock()
+	if err != nil {
+		return contract.GroupDelaySummary{}, err
+	}
 
-	if proxy == nil {
-		delayData.Value = -1
-		data, _ := json.Marshal(delayData)
-		return string(data)
+	ctx, done, err := beginTask(ctx, configuredStates...)
+	if err != nil {
+		return contract.GroupDelaySummary{}, err
+	}
+	defer done()
+
+	started := time.Now()
+	concurrency := params.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultDelayConcurrency
+	}
+
+	results := make([]contract.ProxyDelay, len(targets))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(targets); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				value, stats := measureDelay(ctx, targets[i], opts)
+				if ctx.Err() != nil {
+					continue
+				}
+				results[i] = contract.ProxyDelay{
+					Group:      params.GroupName,
+					Name:       targets[i].Name(),
+					URL:        opts.url,
+					Value:      value,
+					DelayStats: stats,
+				}
+				emitMessage(contract.Message{Type: contract.DelayMessage, Data: results[i]})
+			}
+		}()
+	}
+
+feed:
+	for i := range targets {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
 	}
+	close(jobs)
+	wg.Wait()
 
-	delay, err := proxy.URLTest(ctx, testURL, expectedStatus)
-	if err != nil || delay == 0 {
-		delayData.Value = -1
-	} else {
-		delayData.Value = int32(delay)
+	if err := ctx.Err(); err != nil {
+		return contract.GroupDelaySummary{}, err
 	}
 
-	data, _ := json.Marshal(delayData)
-	return string(data)
+	summary := contract.GroupDelaySummary{
+		Group:   params.GroupName,
+		URL:     opts.url,
+		Tested:  len(results),
+		Results: results,
+	}
+	for _, result := range results {
+		if result.Value > 0 {
+			summary.Alive++
+		} else {
+			summary.Failed++
+		}
+	}
+	summary.DurationMs = time.Since(started).Milliseconds()
+	return summary, nil
+}
+
+// delayTargets resolves the proxies selected by params, without duplicates; the caller holds coreMu.
+func delayTargets(params contract.TestGroupDelayParams) ([]constant.Proxy, error) {
+	proxies := allProxies()
+
+	names := params.ProxyNames
+	if params.GroupName != "" {
+		group, ok := proxies[params.GroupName]
+		if !ok {
+			return nil, contract.NewError(contract.ErrNotFound, "group not found").
+				WithDetails(map[string]string{"group-name": params.GroupName})
+		}
+		var proxyGroup outboundgroup.ProxyGroup
+		if outbound, ok := group.(*adapter.Proxy); ok {
+			proxyGroup, _ = outbound.ProxyAdapter.(outboundgroup.ProxyGroup)
+		}
+		if proxyGroup == nil {
+			return nil, contract.NewError(contract.ErrUnsupported, "not a proxy group").
+				WithDetails(map[string]string{"group-name": params.GroupName})
+		}
+		names = nil
+		for _, member := range proxyGroup.Proxies() {
+			names = append(names, member.Name())
+		}
+	}
+
+	seen := make(map[string]bool, len(names))
+	targets := make([]constant.Proxy, 0, len(names))
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		proxy, ok := proxies[name]
+		if !ok {
+			return nil, contract.NewError(contract.ErrNotFound, "proxy not found").
+				WithDetails(map[string]string{"proxy-name": name})
+		}
+		targets = append(targets, proxy)
+	}
+	return targets, nil
 }
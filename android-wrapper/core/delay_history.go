@@ -0,0 +1,291 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/log"
+)
+
+const (
+	// delayHistoryFile is the name of the persisted delay history under the home dir.
+	delayHistoryFile = "delay_history.json"
+	// delayHistoryLimit bounds the records kept per proxy.
+	delayHistoryLimit = 100
+	// delayHistoryMaxAge is how long records are kept.
+	delayHistoryMaxAge = 7 * 24 * time.Hour
+)
+
+var (
+	delayHistoryMu sync.Mutex
+	// delayHistory holds the records of every proxy by name, oldest first; guarded by delayHistoryMu.
+	delayHistory map[string][]contract.DelayRecord
+	// delayHistoryDirty is set while delayHistory has records that were not persisted; guarded by delayHistoryMu.
+	delayHistoryDirty bool
+)
+
+// delayHistorySeen is the time of the newest mihomo result imported per proxy and test URL; guarded by coreMu.
+var delayHistorySeen map[string]time.Time
+
+// delayTestWindow is the time span of a URLTest run for a host delay test; end is zero while it runs.
+type delayTestWindow struct {
+	start, end time.Time
+}
+
+// delayTestWindows holds the URLTest spans of host tests per proxy and test URL. Those results are
+// recorded as tests already, so collectDelayHistory skips mihomo results inside them. Guarded by
+// delayHistoryMu.
+var delayTestWindows map[string][]*delayTestWindow
+
+func delayHistoryKey(name, url string) string {
+	return name + "\n" + url
+}
+
+// beginDelayTest opens the span of a URLTest run for a host test of the proxy called name and
+// returns the function that closes it.
+func beginDelayTest(name, url string) func() {
+	delayHistoryMu.Lock()
+	defer delayHistoryMu.Unlock()
+
+	if delayTestWindows == nil {
+		delayTestWindows = map[string][]*delayTestWindow{}
+	}
+	key := delayHistoryKey(name, url)
+	window := &delayTestWindow{start: time.Now()}
+	delayTestWindows[key] = append(delayTestWindows[key], window)
+	return func() {
+		delayHistoryMu.Lock()
+		window.end = time.Now()
+		delayHistoryMu.Unlock()
+	}
+}
+
+// hostDelayTest reports whether a mihomo result at t came from a host test, and forgets the spans
+// that ended before seen, which no later result can fall into.
+func hostDelayTest(key string, t, seen time.Time) bool {
+	delayHistoryMu.Lock()
+	defer delayHistoryMu.Unlock()
+
+	windows := delayTestWindows[key][:0]
+	found := false
+	for _, window := range delayTestWindows[key] {
+		if !t.Before(window.start) && (window.end.IsZero() || !t.After(window.end)) {
+			found = true
+		}
+		if window.end.IsZero() || window.end.After(seen) {
+			windows = append(windows, window)
+		}
+	}
+	if len(windows) == 0 {
+		delete(delayTestWindows, key)
+	} else {
+		delayTestWindows[key] = windows
+	}
+	return found
+}
+
+func delayHistoryPath() string {
+	return filepath.Join(constant.Path.HomeDir(), delayHistoryFile)
+}
+
+// loadDelayHistory reads the history from the home dir; a missing file means no history.
+func loadDelayHistory() error {
+	delayHistoryMu.Lock()
+	defer delayHistoryMu.Unlock()
+
+	delayHistory = map[string][]contract.DelayRecord{}
+	delayHistoryDirty = false
+	delayHistorySeen = map[string]time.Time{}
+
+	path := delayHistoryPath()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fileError(err, path)
+	}
+
+	var loaded map[string][]contract.DelayRecord
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return contract.WrapError(contract.ErrConfigParse, err).WithDetails(map[string]string{"path": path})
+	}
+	for name, records := range loaded {
+		delayHistory[name] = records
+	}
+	pruneDelayHistory()
+	return nil
+}
+
+// flushDelayHistory persists the history if it has new records. Failures are logged; the records
+// stay in memory and are written with the next flush.
+func flushDelayHistory() {
+	delayHistoryMu.Lock()
+	defer delayHistoryMu.Unlock()
+
+	if !delayHistoryDirty {
+		return
+	}
+	pruneDelayHistory()
+	data, err := json.Marshal(delayHistory)
+	if err != nil {
+		log.Warnln("[Delay] failed to encode delay history: %s", err.Error())
+		return
+	}
+	if err := writeFileAtomic(delayHistoryPath(), data); err != nil {
+		log.Warnln("[Delay] failed to persist delay history: %s", err.Error())
+		return
+	}
+	delayHistoryDirty = false
+}
+
+// pruneDelayHistory drops records older than delayHistoryMaxAge; the caller holds delayHistoryMu.
+func pruneDelayHistory() {
+	cutoff := time.Now().Add(-delayHistoryMaxAge).UnixMilli()
+	for name, records := range delayHistory {
+		i := sort.Search(len(records), func(i int) bool { return records[i].Time >= cutoff })
+		if i == len(records) {
+			delete(delayHistory, name)
+		} else if i > 0 {
+			delayHistory[name] = append([]contract.DelayRecord(nil), records[i:]...)
+		}
+	}
+}
+
+// recordDelay adds a result to the history of the proxy called name, keeping records in time order.
+func recordDelay(name string, record contract.DelayRecord) {
+	delayHistoryMu.Lock()
+	defer delayHistoryMu.Unlock()
+
+	if delayHistory == nil {
+		delayHistory = map[string][]contract.DelayRecord{}
+	}
+	records := delayHistory[name]
+	i := sort.Search(len(records), func(i int) bool { return records[i].Time > record.Time })
+	records = append(records, contract.DelayRecord{})
+	copy(records[i+1:], records[i:])
+	records[i] = record
+	if len(records) > delayHistoryLimit {
+		records = append([]contract.DelayRecord(nil), records[len(records)-delayHistoryLimit:]...)
+	}
+	delayHistory[name] = records
+	delayHistoryDirty = true
+}
+
+// collectDelayHistory imports the results of mihomo's own URL tests that are newer than the last
+// import, except those of host tests, which measureDelay recorded. mihomo keeps only the last few
+// results per proxy, so this runs with every periodic sync and before a config replaces the proxies.
+// The caller holds coreMu.
+func collectDelayHistory() {
+	if delayHistorySeen == nil {
+		delayHistorySeen = map[string]time.Time{}
+	}
+	for name, proxy := range allProxies() {
+		for url, state := range proxy.ExtraDelayHistories() {
+			key := delayHistoryKey(name, url)
+			seen := delayHistorySeen[key]
+			for _, result := range state.History {
+				if !result.Time.After(delayHistorySeen[key]) {
+					continue
+				}
+				if result.Time.After(seen) {
+					seen = result.Time
+				}
+				if hostDelayTest(key, result.Time, delayHistorySeen[key]) {
+					continue
+				}
+				delay := int32(result.Delay)
+				if delay == 0 {
+					delay = -1
+				}
+				recordDelay(name, contract.DelayRecord{
+					Time:   result.Time.UnixMilli(),
+					Delay:  delay,
+					URL:    url,
+					Source: contract.DelaySourceHealthCheck,
+				})
+			}
+			delayHistorySeen[key] = seen
+		}
+	}
+}
+
+// handleGetDelayHistory returns the history statistics of the requested proxies, or of every proxy
+// with records. The pending results of mihomo's health checks are imported first.
+func handleGetDelayHistory(params contract.DelayHistoryParams) (map[string]contract.ProxyDelayHistory, error) {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if err := requireState(initializedStates...); err != nil {
+		return nil, err
+	}
+	if requireState(configuredStates...) == nil {
+		collectDelayHistory()
+	}
+
+	delayHistoryMu.Lock()
+	defer delayHistoryMu.Unlock()
+
+	names := params.ProxyNames
+	if len(names) == 0 {
+		for name := range delayHistory {
+			names = append(names, name)
+		}
+	}
+	result := make(map[string]contract.ProxyDelayHistory, len(names))
+	for _, name := range names {
+		result[name] = summarizeDelayHistory(delayHistory[name], params.Records)
+	}
+	return result, nil
+}
+
+// summarizeDelayHistory computes the statistics of records, which are in time order.
+func summarizeDelayHistory(records []contract.DelayRecord, withRecords bool) contract.ProxyDelayHistory {
+	summary := contract.ProxyDelayHistory{Count: len(records)}
+	if withRecords {
+		summary.Records = append([]contract.DelayRecord{}, records...)
+	}
+	if len(records) == 0 {
+		return summary
+	}
+
+	var delays []int32
+	for _, record := range records {
+		if record.Delay <= 0 {
+			summary.Failed++
+			continue
+		}
+		delays = append(delays, record.Delay)
+		summary.LastSuccess = record.Time
+	}
+	summary.LossRate = float64(summary.Failed) / float64(summary.Count)
+	summary.LastDelay = records[len(records)-1].Delay
+	if len(delays) == 0 {
+		return summary
+	}
+
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+	summary.Min = delays[0]
+	summary.Max = delays[len(delays)-1]
+	summary.P50 = percentile(delays, 50)
+	summary.P90 = percentile(delays, 90)
+	summary.P99 = percentile(delays, 99)
+	return summary
+}
+
+// percentile returns the nearest-rank percentile p of sorted.
+func percentile(sorted []int32, p int) int32 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,341 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/common/utils"
+	"github.com/metacubex/mihomo/component/ca"
+	"github.com/metacubex/mihomo/component/resolver"
+	"github.com/metacubex/mihomo/constant"
+
+	"github.com/metacubex/http"
+	"github.com/metacubex/http/httptrace"
+	"github.com/metacubex/tls"
+)
+
+// delayDrainLimit bounds how much of a GET response body is read so the connection can be reused.
+const delayDrainLimit = 64 << 10
+
+// delayOptions are the validated request settings of a delay test.
+type delayOptions struct {
+	url            string
+	method         string
+	expectedStatus utils.IntRanges[uint16]
+	timeout        time.Duration
+	samples        int
+	metadata       constant.Metadata
+}
+
+// newDelayOptions validates delay test parameters and fills in defaults.
+func newDelayOptions(testURL, method, expectedStatus string, timeoutMs int64, samples int) (delayOptions, error) {
+	opts := delayOptions{
+		url:     testURL,
+		method:  strings.ToUpper(method),
+		timeout: time.Duration(timeoutMs) * time.Millisecond,
+		samples: samples,
+	}
+	if opts.url == "" {
+		opts.url = constant.DefaultTestURL
+	}
+	if opts.method == "" {
+		opts.method = http.MethodHead
+	}
+	if opts.method != http.MethodHead && opts.method != http.MethodGet {
+		return delayOptions{}, contract.Errorf(contract.ErrInvalidParams, "method %q is not HEAD or GET", method)
+	}
+	if timeoutMs <= 0 {
+		opts.timeout = defaultDelayTimeout * time.Millisecond
+	}
+	if opts.samples <= 0 {
+		opts.samples = 1
+	}
+	if opts.samples > contract.MaxDelaySamples {
+		return delayOptions{}, contract.Errorf(contract.ErrInvalidParams, "samples must be at most %d", contract.MaxDelaySamples)
+	}
+
+	ranges, err := utils.NewUnsignedRanges[uint16](expectedStatus)
+	if err != nil {
+		return delayOptions{}, invalidParams(err).WithDetails(map[string]string{"expected-status": expectedStatus})
+	}
+	opts.expectedStatus = ranges
+
+	if opts.metadata, err = targetMetadata(opts.url, "test-url"); err != nil {
+		return delayOptions{}, err
+	}
+	return opts, nil
+}
+
+// targetMetadata checks that rawURL, the value of the field parameter, is an http or https URL and
+// returns the metadata for dialing its host through a proxy.
+func targetMetadata(rawURL, field string) (constant.Metadata, error) {
+	var metadata constant.Metadata
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return metadata, contract.Errorf(contract.ErrInvalidParams, "%s %q is not an http or https URL", field, rawURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	if err := metadata.SetRemoteAddress(net.JoinHostPort(u.Hostname(), port)); err != nil {
+		return metadata, invalidParams(err)
+	}
+	return metadata, nil
+}
+
+// delayError is a failed sample with the stage it failed in, one of the contract.DelayFailure values.
+type delayError struct {
+	reason string
+	err    error
+}
+
+func (e *delayError) Error() string { return e.err.Error() }
+
+func (e *delayError) Unwrap() error { return e.err }
+
+// measureDelay sends opts.samples requests through proxy, one after another, and returns the average
+// delay, or -1 when every sample failed, with the statistics of the samples. With the default HEAD
+// method the first sample is mihomo's URLTest, so the proxy's alive flag and history follow host
+// tests too. Every sample is added to the proxy's delay history.
+func measureDelay(ctx context.Context, proxy constant.Proxy, opts delayOptions) (int32, contract.DelayStats) {
+	stats := contract.DelayStats{Samples: opts.samples}
+	var delays []int32
+	for i := 0; i < opts.samples && ctx.Err() == nil; i++ {
+		var delay int32
+		var status int
+		var err error
+		if i == 0 && opts.method == http.MethodHead {
+			delay, err = urlTestDelay(ctx, proxy, opts)
+		} else {
+			delay, status, err = sampleDelay(ctx, proxy, opts)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		record := contract.DelayRecord{Time: time.Now().UnixMilli(), Delay: delay, URL: opts.url, Source: contract.DelaySourceTest}
+		if err != nil {
+			record.Delay = -1
+		}
+		recordDelay(proxy.Name(), record)
+		if status != 0 {
+			stats.Status = status
+		}
+		if err != nil {
+			stats.Failed++
+			stats.Reason = contract.DelayFailureConnect
+			var failure *delayError
+			if errors.As(err, &failure) {
+				stats.Reason = failure.reason
+			}
+			stats.Error = err.Error()
+			continue
+		}
+		delays = append(delays, delay)
+	}
+	if len(delays) == 0 {
+		return -1, stats
+	}
+
+	var sum, jitter int64
+	stats.Min, stats.Max = delays[0], delays[0]
+	for i, delay := range delays {
+		sum += int64(delay)
+		if delay < stats.Min {
+			stats.Min = delay
+		}
+		if delay > stats.Max {
+			stats.Max = delay
+		}
+		if i > 0 {
+			diff := int64(delay - delays[i-1])
+			if diff < 0 {
+				diff = -diff
+			}
+			jitter += diff
+		}
+	}
+	stats.Avg = int32(sum / int64(len(delays)))
+	if len(delays) > 1 {
+		stats.Jitter = int32(jitter / int64(len(delays)-1))
+	}
+	return stats.Avg, stats
+}
+
+// urlTestDelay runs mihomo's URLTest on proxy and returns the delay in milliseconds. URLTest does not
+// report the response status or where a request failed, so failures are classified by their error.
+func urlTestDelay(ctx context.Context, proxy constant.Proxy, opts delayOptions) (int32, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	endDelayTest := beginDelayTest(proxy.Name(), opts.url)
+	delay, err := proxy.URLTest(ctx, opts.url, opts.expectedStatus)
+	endDelayTest()
+	if err != nil {
+		return 0, delayFailure(ctx, urlTestFailureReason(err), err)
+	}
+	if opts.expectedStatus != nil && !proxy.AliveForTestUrl(opts.url) {
+		// URLTest succeeds on an unexpected status and only marks the proxy dead for the URL.
+		return 0, &delayError{reason: contract.DelayFailureUnexpectedStatus, err: errors.New("unexpected status")}
+	}
+	if delay == 0 {
+		// Hosts following mihomo read a zero delay as a failure.
+		delay = 1
+	}
+	return int32(delay), nil
+}
+
+// urlTestFailureReason tells the stage a URLTest error came from.
+func urlTestFailureReason(err error) string {
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &recordErr) || errors.As(err, &alertErr) || errors.As(err, &certErr) {
+		return contract.DelayFailureTLS
+	}
+	// The http client reports some handshake failures with its own text only.
+	message := err.Error()
+	if strings.Contains(message, "tls: ") || strings.Contains(message, "x509: ") ||
+		strings.Contains(message, "server gave HTTP response to HTTPS client") {
+		return contract.DelayFailureTLS
+	}
+	return dialFailureReason(err)
+}
+
+// sampleDelay sends one request through proxy like mihomo's URLTest and returns the delay in
+// milliseconds and the response status.
+func sampleDelay(ctx context.Context, proxy constant.Proxy, opts delayOptions) (int32, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	start := time.Now()
+	metadata := opts.metadata
+	conn, err := proxy.DialContext(ctx, &metadata)
+	if err != nil {
+		return 0, 0, delayFailure(ctx, dialFailureReason(err), err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	client, err := newDelayClient(conn)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer client.CloseIdleConnections()
+
+	resp, err := sendDelayRequest(ctx, client, opts)
+	if err != nil {
+		return 0, 0, err
+	}
+	drainResponse(resp)
+
+	// Like URLTest with unified-delay, time a second request over the established connection.
+	if adapter.UnifiedDelay.Load() {
+		second := time.Now()
+		if again, err := sendDelayRequest(ctx, client, opts); err == nil {
+			drainResponse(again)
+			resp, start = again, second
+		}
+	}
+
+	delay := int32(time.Since(start) / time.Millisecond)
+	if delay == 0 {
+		// Hosts following mihomo read a zero delay as a failure.
+		delay = 1
+	}
+	if opts.expectedStatus != nil && !opts.expectedStatus.Check(uint16(resp.StatusCode)) {
+		return 0, resp.StatusCode, &delayError{
+			reason: contract.DelayFailureUnexpectedStatus,
+			err:    fmt.Errorf("unexpected status %d", resp.StatusCode),
+		}
+	}
+	return delay, resp.StatusCode, nil
+}
+
+// newDelayClient returns a client that sends its requests over conn and does not follow redirects.
+func newDelayClient(conn net.Conn) (*http.Client, error) {
+	tlsConfig, err := ca.GetTLSConfig(ca.Option{})
+	if err != nil {
+		return nil, &delayError{reason: contract.DelayFailureTLS, err: err}
+	}
+	transport := &http.Transport{
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			return conn, nil
+		},
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     tlsConfig,
+	}
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
+
+// sendDelayRequest sends the request described by opts; failures are *delayError values.
+func sendDelayRequest(ctx context.Context, client *http.Client, opts delayOptions) (*http.Response, error) {
+	var handshaking atomic.Bool
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { handshaking.Store(true) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				handshaking.Store(false)
+			}
+		},
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), opts.method, opts.url, nil)
+	if err != nil {
+		return nil, invalidParams(err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		reason := contract.DelayFailureConnect
+		if handshaking.Load() {
+			reason = contract.DelayFailureTLS
+		}
+		return nil, delayFailure(ctx, reason, err)
+	}
+	return resp, nil
+}
+
+// drainResponse reads a bounded part of the body and closes it.
+func drainResponse(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, delayDrainLimit))
+	_ = resp.Body.Close()
+}
+
+// delayFailure wraps err with reason, or with DelayFailureTimeout when the sample ran out of time.
+func delayFailure(ctx context.Context, reason string, err error) error {
+	var netErr net.Error
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		reason = contract.DelayFailureTimeout
+	}
+	return &delayError{reason: reason, err: err}
+}
+
+// dialFailureReason tells resolving the proxy server from connecting to it.
+func dialFailureReason(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) || errors.Is(err, resolver.ErrIPNotFound) ||
+		errors.Is(err, resolver.ErrIPVersion) || errors.Is(err, resolver.ErrIPv6Disabled) {
+		return contract.DelayFailureDNS
+	}
+	return contract.DelayFailureConnect
+}
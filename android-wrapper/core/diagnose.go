@@ -0,0 +1,274 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/component/ca"
+	"github.com/metacubex/mihomo/component/dialer"
+	"github.com/metacubex/mihomo/component/resolver"
+	"github.com/metacubex/mihomo/constant"
+
+	"github.com/metacubex/tls"
+)
+
+// diagnosis collects the stages of one diagnoseProxy run.
+type diagnosis struct {
+	contract.ProxyDiagnosis
+	timeout time.Duration
+}
+
+// run times stage with its own timeout and records the outcome; it is skipped once a stage failed.
+func (d *diagnosis) run(ctx context.Context, name string, stage func(ctx context.Context) (string, error)) {
+	if d.FailedStage != "" {
+		d.skip(name, "stage "+d.FailedStage+" failed")
+		return
+	}
+
+	stageCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	started := time.Now()
+	detail, err := stage(stageCtx)
+	result := contract.DiagnosisStage{
+		Name:       name,
+		Status:     contract.StageOK,
+		DurationMs: time.Since(started).Milliseconds(),
+		Detail:     detail,
+	}
+	if err != nil {
+		result.Status = contract.StageFailed
+		result.Error = err.Error()
+		d.FailedStage = name
+	}
+	d.Stages = append(d.Stages, result)
+}
+
+// skip records a stage that does not apply to the proxy.
+func (d *diagnosis) skip(name, reason string) {
+	d.Stages = append(d.Stages, contract.DiagnosisStage{Name: name, Status: contract.StageSkipped, Detail: reason})
+}
+
+// handleDiagnoseProxy checks a proxy one stage at a time: resolving its server with the core
+// resolver, connecting to it over TCP or UDP, the TLS handshake of TLS-based protocols (REALITY
+// excluded), the protocol handshake and finally the test request. Every stage gets params.Timeout on its own, and the first failure skips
+// the rest. Cancelling ctx (or shutdown) aborts the run and reports the context error.
+func handleDiagnoseProxy(ctx context.Context, params contract.DiagnoseProxyParams) (contract.ProxyDiagnosis, error) {
+	if err := requireState(configuredStates...); err != nil {
+		return contract.ProxyDiagnosis{}, err
+	}
+
+	opts, err := newDelayOptions(params.TestURL, "", params.ExpectedStatus, params.Timeout, 1)
+	if err != nil {
+		return contract.ProxyDiagnosis{}, err
+	}
+
+	coreMu.Lock()
+	proxy := allProxies()[params.ProxyName]
+	var settings map[string]any
+	if proxy != nil && proxy.ProxyInfo().ProviderName == "" {
+		settings, err = configProxy(params.ProxyName)
+	}
+	coreMu.Unlock()
+	if err != nil {
+		return contract.ProxyDiagnosis{}, err
+	}
+	if proxy == nil {
+		return contract.ProxyDiagnosis{}, contract.NewError(contract.ErrNotFound, "proxy not found").
+			WithDetails(map[string]string{"proxy-name": params.ProxyName})
+	}
+
+	ctx, done, err := beginTask(ctx, configuredStates...)
+	if err != nil {
+		return contract.ProxyDiagnosis{}, err
+	}
+	defer done()
+
+	d := &diagnosis{
+		ProxyDiagnosis: contract.ProxyDiagnosis{
+			Name:   proxy.Name(),
+			Type:   proxy.Type().String(),
+			Server: proxy.Addr(),
+			URL:    opts.url,
+			Stages: []contract.DiagnosisStage{},
+		},
+		timeout: opts.timeout,
+	}
+	diagnoseServer(ctx, d, proxy, settings)
+	diagnoseTunnel(ctx, d, proxy, opts)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return contract.ProxyDiagnosis{}, ctxErr
+	}
+	d.OK = d.FailedStage == ""
+	return d.ProxyDiagnosis, nil
+}
+
+// diagnoseServer runs the resolve, connect and tls stages against the proxy server directly.
+func diagnoseServer(ctx context.Context, d *diagnosis, proxy constant.Proxy, settings map[string]any) {
+	info := proxy.ProxyInfo()
+	reason := ""
+	switch {
+	case proxy.Addr() == "":
+		reason = "the proxy has no server address"
+	case info.DialerProxy != "":
+		reason = "the server is reached through dialer-proxy " + info.DialerProxy
+	}
+	if reason != "" {
+		d.skip(contract.DiagnosisResolve, reason)
+		d.skip(contract.DiagnosisConnect, reason)
+		d.skip(contract.DiagnosisTLS, reason)
+		return
+	}
+
+	var host, port string
+	var server netip.Addr
+	d.run(ctx, contract.DiagnosisResolve, func(ctx context.Context) (string, error) {
+		var err error
+		if host, port, err = net.SplitHostPort(proxy.Addr()); err != nil {
+			return "", err
+		}
+		if server, err = netip.ParseAddr(host); err == nil {
+			return "ip address", nil
+		}
+		if server, err = resolver.ResolveIPWithResolver(ctx, host, resolver.ProxyServerHostResolver); err != nil {
+			return "", err
+		}
+		return server.String(), nil
+	})
+
+	network := "tcp"
+	switch proxy.Type() {
+	case constant.Hysteria, constant.Hysteria2, constant.Tuic, constant.WireGuard:
+		network = "udp"
+	}
+
+	var conn net.Conn
+	d.run(ctx, contract.DiagnosisConnect, func(ctx context.Context) (string, error) {
+		var err error
+		conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(server.String(), port),
+			dialer.WithInterface(info.Interface), dialer.WithRoutingMark(info.RoutingMark))
+		if err != nil {
+			return "", err
+		}
+		if network == "udp" {
+			// UDP has no connection setup; whether the server answers shows in the handshake stage.
+			return "udp " + conn.RemoteAddr().String() + ", not acknowledged", nil
+		}
+		return conn.RemoteAddr().String(), nil
+	})
+	if conn == nil {
+		d.skip(contract.DiagnosisTLS, "stage "+d.FailedStage+" failed")
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	switch {
+	case network == "udp":
+		d.skip(contract.DiagnosisTLS, proxy.Type().String()+" runs its handshake over UDP; the handshake stage covers it")
+		return
+	case settings == nil:
+		d.skip(contract.DiagnosisTLS, "tls settings are only known for proxies of the config")
+		return
+	case objectValue(settings, "reality-opts") != nil:
+		// A REALITY server hands a plain TLS client to its camouflage target, which would pass with a
+		// wrong public key or short id.
+		d.skip(contract.DiagnosisTLS, "reality is only checked by the handshake stage")
+		return
+	}
+	config, err := diagnosisTLSConfig(proxy.Type(), settings, host)
+	if err == nil && config == nil {
+		d.skip(contract.DiagnosisTLS, "the proxy does not use tls")
+		return
+	}
+	d.run(ctx, contract.DiagnosisTLS, func(ctx context.Context) (string, error) {
+		if err != nil {
+			return "", err
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return "", err
+		}
+		state := tlsConn.ConnectionState()
+		detail := tls.VersionName(state.Version) + ", server name " + config.ServerName
+		if state.NegotiatedProtocol != "" {
+			detail += ", alpn " + state.NegotiatedProtocol
+		}
+		return detail, nil
+	})
+}
+
+// diagnosisTLSConfig returns the client config of the TLS layer the proxy uses towards its server, or
+// nil when it uses none.
+func diagnosisTLSConfig(proxyType constant.AdapterType, settings map[string]any, host string) (*tls.Config, error) {
+	switch proxyType {
+	case constant.Trojan, constant.AnyTLS:
+	case constant.Vmess, constant.Vless, constant.Http, constant.Socks5:
+		if !boolValue(settings, "tls") {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         stringValue(settings, "servername"),
+		InsecureSkipVerify: boolValue(settings, "skip-cert-verify"),
+	}
+	if config.ServerName == "" {
+		config.ServerName = stringValue(settings, "sni")
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	if alpn := listValue(settings, "alpn"); alpn != "" {
+		config.NextProtos = strings.Split(alpn, ",")
+	}
+	return ca.GetTLSConfig(ca.Option{TLSConfig: config, Fingerprint: stringValue(settings, "fingerprint")})
+}
+
+// diagnoseTunnel runs the handshake and http stages through the proxy itself.
+func diagnoseTunnel(ctx context.Context, d *diagnosis, proxy constant.Proxy, opts delayOptions) {
+	var conn net.Conn
+	d.run(ctx, contract.DiagnosisHandshake, func(ctx context.Context) (string, error) {
+		metadata := opts.metadata
+		c, err := proxy.DialContext(ctx, &metadata)
+		if err != nil {
+			return "", err
+		}
+		conn = c
+		return metadata.RemoteAddress(), nil
+	})
+	if conn == nil {
+		d.skip(contract.DiagnosisHTTP, "stage "+d.FailedStage+" failed")
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	d.run(ctx, contract.DiagnosisHTTP, func(ctx context.Context) (string, error) {
+		client, err := newDelayClient(conn)
+		if err != nil {
+			return "", err
+		}
+		defer client.CloseIdleConnections()
+
+		resp, err := sendDelayRequest(ctx, client, opts)
+		if err != nil {
+			return "", err
+		}
+		drainResponse(resp)
+		detail := fmt.Sprintf("status %d", resp.StatusCode)
+		if opts.expectedStatus != nil && !opts.expectedStatus.Check(uint16(resp.StatusCode)) {
+			return detail, fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return detail, nil
+	})
+}
@@ -0,0 +1,30 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/metacubex/mihomo/log"
+)
+
+// handleUpdateDns applies a comma-separated list of system DNS servers via the DNSUpdater.
+func handleUpdateDns(value string) {
+	updater := dnsUpdater
+	if updater == nil {
+		log.Warnln("[DNS] system DNS update is not supported in this build")
+		return
+	}
+
+	go func() {
+		log.Infoln("[DNS] update system DNS: %s", value)
+
+		var addrs []string
+		for _, p := range strings.Split(value, ",") {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			addrs = append(addrs, p)
+		}
+		updater.UpdateSystemDNS(addrs)
+	}()
+}
@@ -1,34 +1,16 @@
-//go:build android && cgo && cmfa
+//go:build android && cmfa
 
 package core
 
-import (
-	"strings"
+import "github.com/metacubex/mihomo/dns"
 
-	"github.com/metacubex/mihomo/dns"
-	"github.com/metacubex/mihomo/log"
-)
+// systemResolver updates mihomo's Android system resolver (cmfa builds only).
+type systemResolver struct{}
 
-// handleUpdateDns updates system DNS (Android cmfa builds only).
-func handleUpdateDns(value string) {
-	go func() {
-		log.Infoln("[DNS] update system DNS: %s", value)
-
-		if strings.TrimSpace(value) == "" {
-			dns.UpdateSystemDNS(nil)
-		} else {
-			parts := strings.Split(value, ",")
-			addrs := make([]string, 0, len(parts))
-			for _, p := range parts {
-				p = strings.TrimSpace(p)
-				if p == "" {
-					continue
-				}
-				addrs = append(addrs, p)
-			}
-			dns.UpdateSystemDNS(addrs)
-		}
-
-		dns.FlushCacheWithDefaultResolver()
-	}()
+// UpdateSystemDNS replaces the system DNS servers and flushes the DNS cache.
+func (systemResolver) UpdateSystemDNS(servers []string) {
+	dns.UpdateSystemDNS(servers)
+	dns.FlushCacheWithDefaultResolver()
 }
+
+var defaultDNSUpdater DNSUpdater = systemResolver{}
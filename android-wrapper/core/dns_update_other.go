@@ -0,0 +1,6 @@
+//go:build !android || !cmfa
+
+package core
+
+// defaultDNSUpdater is nil: without cmfa on Android, mihomo has no system resolver to update.
+var defaultDNSUpdater DNSUpdater
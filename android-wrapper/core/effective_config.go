@@ -0,0 +1,164 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/hub/executor"
+)
+
+// redactedValue replaces sensitive values in a redacted config.
+const redactedValue = "<redacted>"
+
+// sensitiveKeys are keys whose values are redacted wherever they appear in the config.
+var sensitiveKeys = map[string]bool{
+	"password":               true,
+	"uuid":                   true,
+	"private-key":            true,
+	"private-key-passphrase": true,
+	"pre-shared-key":         true,
+	"psk":                    true,
+	"auth":                   true,
+	"auth-str":               true,
+	"obfs-password":          true,
+	"token":                  true,
+	"secret":                 true,
+	"short-id":               true,
+	"users":                  true,
+	"ss-config":              true,
+	"vmess-config":           true,
+}
+
+// handleGetEffectiveConfig returns the config the core is running: the applied raw config with host
+// overrides and updateConfig patches merged in, mihomo defaults filled in, and the runtime general
+// settings (including single-field updateConfig overrides and the disabled mihomo TUN) on top.
+func handleGetEffectiveConfig(params contract.EffectiveConfigParams) (contract.EffectiveConfig, error) {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if err := requireState(configuredStates...); err != nil {
+		return contract.EffectiveConfig{}, err
+	}
+
+	data, err := currentConfigData()
+	if err != nil {
+		return contract.EffectiveConfig{}, err
+	}
+	cfg, err := rawConfigMap(data)
+	if err != nil {
+		return contract.EffectiveConfig{}, err
+	}
+	cfg["rules"] = cfg["rule"]
+	delete(cfg, "rule")
+
+	if err := applyRuntimeGeneral(cfg); err != nil {
+		return contract.EffectiveConfig{}, err
+	}
+
+	effective := contract.EffectiveConfig{
+		Redacted: params.Redact == nil || *params.Redact,
+		Config:   cfg,
+		Selected: currentSelections(),
+	}
+	if appliedConfig != nil {
+		effective.Source = appliedConfig.source
+	} else {
+		effective.Source = constant.Path.Config()
+	}
+	if effective.Redacted {
+		redactConfig(cfg)
+	}
+	return effective, nil
+}
+
+// applyRuntimeGeneral overwrites the top-level settings of cfg with the values the running core reports.
+func applyRuntimeGeneral(cfg map[string]any) error {
+	encoded, err := json.Marshal(executor.GetGeneral())
+	if err != nil {
+		return contract.WrapError(contract.ErrInternal, err)
+	}
+	var general map[string]any
+	if err := json.Unmarshal(encoded, &general); err != nil {
+		return contract.WrapError(contract.ErrInternal, err)
+	}
+
+	for key, value := range general {
+		if _, isObject := value.(map[string]any); isObject {
+			continue
+		}
+		if _, ok := cfg[key]; ok {
+			cfg[key] = value
+		}
+	}
+	if sniffer, ok := cfg["sniffer"].(map[string]any); ok {
+		sniffer["enable"] = general["sniffing"]
+	}
+	if tun, ok := cfg["tun"].(map[string]any); ok {
+		if runtimeTun, ok := general["tun"].(map[string]any); ok {
+			tun["enable"] = runtimeTun["enable"]
+		}
+	}
+	return nil
+}
+
+// redactConfig replaces secrets in cfg in place: sensitive keys anywhere, the passwords in
+// authentication entries ("user:pass"), and proxy provider URLs and headers, which usually carry
+// subscription tokens.
+func redactConfig(cfg map[string]any) {
+	redactValue(cfg)
+
+	if users, ok := cfg["authentication"].([]any); ok {
+		for i, entry := range users {
+			entry, _ := entry.(string)
+			if user, _, found := strings.Cut(entry, ":"); found {
+				users[i] = user + ":" + redactedValue
+			}
+		}
+	}
+	if providers, ok := cfg["proxy-providers"].(map[string]any); ok {
+		for _, provider := range providers {
+			if provider, ok := provider.(map[string]any); ok {
+				for _, key := range []string{"url", "header"} {
+					if value, ok := provider[key]; ok && holdsData(value) {
+						provider[key] = redactedValue
+					}
+				}
+			}
+		}
+	}
+}
+
+// redactValue walks a generic JSON value and redacts the data held by sensitive keys.
+func redactValue(value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if sensitiveKeys[key] && holdsData(child) {
+				v[key] = redactedValue
+				continue
+			}
+			redactValue(child)
+		}
+	case []any:
+		for _, child := range v {
+			redactValue(child)
+		}
+	}
+}
+
+// holdsData reports whether v is a non-empty string, list or object; flags, numbers and empty
+// values reveal nothing and are kept.
+func holdsData(v any) bool {
+	switch v := v.(type) {
+	case string:
+		return v != ""
+	case []any:
+		return len(v) > 0
+	case map[string]any:
+		return len(v) > 0
+	}
+	return false
+}
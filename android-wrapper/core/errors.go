@@ -0,0 +1,98 @@
+package core
+
+import (
+	"errors"
+	"os"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/config"
+	"github.com/metacubex/mihomo/hub/executor"
+)
+
+var errNotInitialized = contract.NewError(contract.ErrNotInitialized, "not initialized")
+
+// invalidParams reports a malformed handler payload as ErrInvalidParams.
+func invalidParams(err error) *contract.Error {
+	return contract.Errorf(contract.ErrInvalidParams, "invalid params: %s", err.Error())
+}
+
+// missingParam reports a required payload field that is absent or empty.
+func missingParam(name string) *contract.Error {
+	return contract.Errorf(contract.ErrInvalidParams, "missing %s", name).
+		WithDetails(map[string]string{"param": name})
+}
+
+// fileError classifies a filesystem error as ErrNotFound or ErrIO.
+func fileError(err error, path string) *contract.Error {
+	code := contract.ErrIO
+	if errors.Is(err, os.ErrNotExist) {
+		code = contract.ErrNotFound
+	}
+	return contract.WrapError(code, err).WithDetails(map[string]string{"path": path})
+}
+
+// parseConfigFile reads and parses a config file, separating IO failures from parse failures.
+func parseConfigFile(path string) (*config.Config, error) {
+	data, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigBytes(data)
+}
+
+// readConfigFile reads a config file, reporting an empty file as ErrConfigParse.
+func readConfigFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fileError(err, path)
+	}
+	if len(data) == 0 {
+		return nil, contract.Errorf(contract.ErrConfigParse, "configuration file %s is empty", path).
+			WithDetails(map[string]string{"path": path})
+	}
+	return data, nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path, so a crash never leaves
+// a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fileError(err, tmp)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fileError(err, path)
+	}
+	return nil
+}
+
+// readConfigSource returns the payload, or the content of the config file, selected by params.
+func readConfigSource(params contract.ConfigSourceParams) ([]byte, error) {
+	if params.Payload != "" {
+		return []byte(params.Payload), nil
+	}
+	if params.ConfigPath == "" {
+		return nil, missingParam("config-path")
+	}
+	return readConfigFile(params.ConfigPath)
+}
+
+// parseConfigBytes parses config content with the host overrides merged in, reporting failures as ErrConfigParse.
+func parseConfigBytes(data []byte) (*config.Config, error) {
+	return parseWithOverrides(data, overrides)
+}
+
+// parseWithOverrides parses config content with o merged in.
+func parseWithOverrides(data []byte, o contract.Overrides) (*config.Config, error) {
+	merged, err := mergeOverrides(data, o)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := executor.ParseWithBytes(merged)
+	if err != nil {
+		return nil, contract.WrapError(contract.ErrConfigParse, err)
+	}
+	return cfg, nil
+}
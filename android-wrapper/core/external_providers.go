@@ -1,14 +1,16 @@
-//go:build android && cgo
-
 package core
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"sort"
 	"time"
 
+	"mihomo_android_wrapper/contract"
+
 	"github.com/metacubex/mihomo/adapter/provider"
+	"github.com/metacubex/mihomo/common/utils"
 	"github.com/metacubex/mihomo/component/profile/cachefile"
 	cp "github.com/metacubex/mihomo/constant/provider"
 	rp "github.com/metacubex/mihomo/rules/provider"
@@ -79,8 +81,14 @@ func toExternalProvider(p cp.Provider) (*ExternalProvider, error) {
 	}
 }
 
+// errExternalProviderNotFound reports an unknown external provider name as ErrNotFound.
+func errExternalProviderNotFound(name string) *contract.Error {
+	return contract.NewError(contract.ErrNotFound, "external provider not found").
+		WithDetails(map[string]string{"provider-name": name})
+}
+
 // handleGetExternalProviders returns a JSON list of all external providers.
-func handleGetExternalProviders() string {
+func handleGetExternalProviders() (string, error) {
 	coreMu.Lock()
 	defer coreMu.Unlock()
 
@@ -97,19 +105,55 @@ func handleGetExternalProviders() string {
 
 	data, err := json.Marshal(list)
 	if err != nil {
-		return ""
+		return "", contract.WrapError(contract.ErrInternal, err)
 	}
-	return string(data)
+	return string(data), nil
 }
 
 // handleUpdateExternalProvider triggers an update on the specified external provider.
-func handleUpdateExternalProvider(providerName string) string {
+func handleUpdateExternalProvider(ctx context.Context, providerName string) error {
+	ctx, done, err := beginTask(ctx, configuredStates...)
+	if err != nil {
+		return err
+	}
+	defer done()
+
 	p := getExternalProvidersRaw()[providerName]
 	if p == nil {
-		return "external provider not found"
+		return errExternalProviderNotFound(providerName)
+	}
+	if err := updateExternalProvider(ctx, p); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return contract.WrapError(contract.ErrIO, err)
+	}
+	return nil
+}
+
+// updateExternalProvider refreshes a provider, downloading HTTP content under ctx so it can be aborted.
+// Non-HTTP providers fall back to the provider's own Update.
+func updateExternalProvider(ctx context.Context, p cp.Provider) error {
+	if p.VehicleType() != cp.HTTP {
+		return p.Update()
+	}
+
+	var vehicle cp.Vehicle
+	switch pv := p.(type) {
+	case *provider.ProxySetProvider:
+		vehicle = pv.Vehicle()
+	case *rp.RuleSetProvider:
+		vehicle = pv.Vehicle()
+	default:
+		return p.Update()
+	}
+
+	data, _, err := vehicle.Read(ctx, utils.HashType{})
+	if err != nil {
+		return err
 	}
-	if err := p.Update(); err != nil {
-		return err.Error()
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	return ""
+	return sideUpdateExternalProvider(p, data)
 }
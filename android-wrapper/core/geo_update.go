@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/common/utils"
+	"github.com/metacubex/mihomo/component/geodata"
+	_ "github.com/metacubex/mihomo/component/geodata/standard"
+	"github.com/metacubex/mihomo/component/mmdb"
+	"github.com/metacubex/mihomo/component/resource"
+	"github.com/metacubex/mihomo/constant"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoUpdateTimeout matches the upstream updater's per-download timeout.
+const geoUpdateTimeout = 90 * time.Second
+
+// geoDatabase describes how to download, validate and install one Geo database.
+// It mirrors component/updater, but downloads under a caller context so updates can be cancelled.
+type geoDatabase struct {
+	name     string
+	url      string
+	path     string
+	validate func(data []byte) error
+	install  func(write func() error) error
+}
+
+// geoDatabaseFor returns the database for a geo-type payload value, or nil if unknown.
+func geoDatabaseFor(geoType string) *geoDatabase {
+	switch geoType {
+	case "MMDB":
+		return &geoDatabase{
+			name:     "MMDB",
+			url:      geodata.MmdbUrl(),
+			path:     constant.Path.MMDB(),
+			validate: validateMaxMind,
+			install: func(write func() error) error {
+				defer mmdb.ReloadIP()
+				// mmdb is loaded with mmap, so it needs to be closed before overwriting the file.
+				mmdb.IPInstance().Reader.Close()
+				return write()
+			},
+		}
+	case "ASN":
+		return &geoDatabase{
+			name:     "ASN",
+			url:      geodata.ASNUrl(),
+			path:     constant.Path.ASN(),
+			validate: validateMaxMind,
+			install: func(write func() error) error {
+				defer mmdb.ReloadASN()
+				mmdb.ASNInstance().Reader.Close()
+				return write()
+			},
+		}
+	case "GEOIP":
+		return &geoDatabase{
+			name: "GeoIP",
+			url:  geodata.GeoIpUrl(),
+			path: constant.Path.GeoIP(),
+			validate: func(data []byte) error {
+				loader, err := geodata.GetGeoDataLoader("standard")
+				if err != nil {
+					return err
+				}
+				_, err = loader.LoadIPByBytes(data, "cn")
+				return err
+			},
+			install: func(write func() error) error {
+				defer geodata.ClearGeoIPCache()
+				return write()
+			},
+		}
+	case "GEOSITE":
+		return &geoDatabase{
+			name: "GeoSite",
+			url:  geodata.GeoSiteUrl(),
+			path: constant.Path.GeoSite(),
+			validate: func(data []byte) error {
+				loader, err := geodata.GetGeoDataLoader("standard")
+				if err != nil {
+					return err
+				}
+				_, err = loader.LoadSiteByBytes(data, "cn")
+				return err
+			},
+			install: func(write func() error) error {
+				defer geodata.ClearGeoSiteCache()
+				return write()
+			},
+		}
+	default:
+		return nil
+	}
+}
+
+// validateMaxMind checks that data is a readable MaxMind database.
+func validateMaxMind(data []byte) error {
+	instance, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return err
+	}
+	return instance.Close()
+}
+
+// updateGeoDatabase downloads db under ctx and installs it if the content changed and is valid.
+func updateGeoDatabase(ctx context.Context, db *geoDatabase) error {
+	vehicle := resource.NewHTTPVehicle(db.url, db.path, "", nil, geoUpdateTimeout, 0)
+
+	var oldHash utils.HashType
+	if buf, err := os.ReadFile(vehicle.Path()); err == nil {
+		oldHash = utils.MakeHash(buf)
+	}
+
+	data, hash, err := vehicle.Read(ctx, oldHash)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return contract.Errorf(contract.ErrIO, "can't download %s database file: %s", db.name, err.Error())
+	}
+	if oldHash.Equal(hash) {
+		return nil
+	}
+	if len(data) == 0 {
+		return contract.Errorf(contract.ErrIO, "can't download %s database file: no data", db.name)
+	}
+
+	if err := db.validate(data); err != nil {
+		return contract.Errorf(contract.ErrConfigParse, "invalid %s database file: %s", db.name, err.Error())
+	}
+
+	if err := db.install(func() error { return vehicle.Write(data) }); err != nil {
+		return fileError(err, db.path)
+	}
+	return nil
+}
@@ -0,0 +1,561 @@
+package core_test
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mihomo_android_wrapper/api"
+	"mihomo_android_wrapper/contract"
+	"mihomo_android_wrapper/core"
+
+	N "github.com/metacubex/mihomo/common/net"
+	C "github.com/metacubex/mihomo/constant"
+	LC "github.com/metacubex/mihomo/listener/config"
+	"github.com/metacubex/mihomo/listener/sing_shadowsocks"
+)
+
+const (
+	ssCipher   = "aes-128-gcm"
+	ssPassword = "conformance"
+	// largeBodySize is the size of the body the local target serves at /large.
+	largeBodySize = 1 << 20
+)
+
+// recorder is a contract.Emitter that keeps every emitted message.
+type recorder struct {
+	mu       sync.Mutex
+	messages []contract.Message
+}
+
+// Emit implements contract.Emitter.
+func (r *recorder) Emit(message contract.Message) {
+	r.mu.Lock()
+	r.messages = append(r.messages, message)
+	r.mu.Unlock()
+}
+
+// reset drops recorded messages.
+func (r *recorder) reset() {
+	r.mu.Lock()
+	r.messages = nil
+	r.mu.Unlock()
+}
+
+// waitFor waits until a message of type typ matching match has been emitted.
+func (r *recorder) waitFor(t *testing.T, typ contract.MessageType, timeout time.Duration, match func(data any) bool) contract.Message {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		r.mu.Lock()
+		for _, m := range r.messages {
+			if m.Type == typ && (match == nil || match(m.Data)) {
+				r.mu.Unlock()
+				return m
+			}
+		}
+		r.mu.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatalf("no %s message within %s", typ, timeout)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// states returns the State of every recorded StateMessage, in emission order.
+func (r *recorder) states() []contract.State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var states []contract.State
+	for _, m := range r.messages {
+		if info, ok := m.Data.(contract.StateInfo); ok && m.Type == contract.StateMessage {
+			states = append(states, info.State)
+		}
+	}
+	return states
+}
+
+// count returns the number of recorded messages of type typ.
+func (r *recorder) count(typ contract.MessageType) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, m := range r.messages {
+		if m.Type == typ {
+			n++
+		}
+	}
+	return n
+}
+
+// wireResponse is a Response as the host receives it after JSON encoding.
+type wireResponse struct {
+	ID     string          `json:"id"`
+	Method contract.Method `json:"method"`
+	Data   json.RawMessage `json:"data"`
+	Code   int             `json:"code"`
+}
+
+// harness drives a real core.Service through api.Dispatcher.
+type harness struct {
+	service    *core.Service
+	dispatcher *api.Dispatcher
+	events     *recorder
+	home       string
+
+	mu     sync.Mutex
+	nextID int
+	called map[contract.Method]bool
+}
+
+func newHarness(t *testing.T) *harness {
+	events := &recorder{}
+	service := core.New(core.Options{Emitter: events})
+	return &harness{
+		service:    service,
+		dispatcher: api.New(service),
+		events:     events,
+		home:       t.TempDir(),
+		called:     make(map[contract.Method]bool),
+	}
+}
+
+// action builds an Action with a fresh id; data is JSON-encoded unless it is already json.RawMessage.
+func (h *harness) action(t *testing.T, method contract.Method, data any) contract.Action {
+	t.Helper()
+	raw, ok := data.(json.RawMessage)
+	if !ok {
+		var err error
+		raw, err = json.Marshal(data)
+		if err != nil {
+			t.Fatalf("marshal %s data: %v", method, err)
+		}
+	}
+	h.mu.Lock()
+	h.nextID++
+	id := fmt.Sprintf("t-%d", h.nextID)
+	h.mu.Unlock()
+	return contract.Action{ID: id, Method: method, Data: raw}
+}
+
+// dispatch runs action and returns the JSON-decoded response.
+func (h *harness) dispatch(t *testing.T, action contract.Action) wireResponse {
+	t.Helper()
+	h.mu.Lock()
+	h.called[action.Method] = true
+	h.mu.Unlock()
+
+	result := h.dispatcher.Dispatch(context.Background(), action)
+	raw, err := json.Marshal(result.Response)
+	if err != nil {
+		t.Fatalf("%s: marshal response: %v", action.Method, err)
+	}
+	var resp wireResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		t.Fatalf("%s: unmarshal response: %v", action.Method, err)
+	}
+	if resp.ID != action.ID || resp.Method != action.Method {
+		t.Fatalf("%s: response id/method = %q/%q, want %q/%q", action.Method, resp.ID, resp.Method, action.ID, action.Method)
+	}
+	return resp
+}
+
+// ok dispatches method and decodes successful response data into out (if non-nil).
+func (h *harness) ok(t *testing.T, method contract.Method, data any, out any) {
+	t.Helper()
+	resp := h.dispatch(t, h.action(t, method, data))
+	if resp.Code != 0 {
+		t.Fatalf("%s: code=%d data=%s", method, resp.Code, resp.Data)
+	}
+	if out != nil {
+		if err := json.Unmarshal(resp.Data, out); err != nil {
+			t.Fatalf("%s: decode data %s: %v", method, resp.Data, err)
+		}
+	}
+}
+
+// fail dispatches method and asserts it fails with code.
+func (h *harness) fail(t *testing.T, method contract.Method, data any, code contract.ErrorCode) contract.Error {
+	t.Helper()
+	return h.expectError(t, h.dispatch(t, h.action(t, method, data)), code)
+}
+
+// expectError asserts resp is a failure with the given error code.
+func (h *harness) expectError(t *testing.T, resp wireResponse, code contract.ErrorCode) contract.Error {
+	t.Helper()
+	if resp.Code != -1 {
+		t.Fatalf("%s: code=%d data=%s, want failure %s", resp.Method, resp.Code, resp.Data, code)
+	}
+	var e contract.Error
+	if err := json.Unmarshal(resp.Data, &e); err != nil {
+		t.Fatalf("%s: decode error %s: %v", resp.Method, resp.Data, err)
+	}
+	if e.Code != code {
+		t.Fatalf("%s: error code=%s (%s), want %s", resp.Method, e.Code, e.Message, code)
+	}
+	if e.Message == "" {
+		t.Fatalf("%s: empty error message", resp.Method)
+	}
+	return e
+}
+
+// expectState asserts the getState result.
+func (h *harness) expectState(t *testing.T, want contract.State) contract.StateInfo {
+	t.Helper()
+	var info contract.StateInfo
+	h.ok(t, contract.GetStateMethod, nil, &info)
+	if info.State != want {
+		t.Fatalf("getState = %+v, want %s", info, want)
+	}
+	return info
+}
+
+// jsonString decodes data that is itself a JSON document encoded as a string (getTraffic, getConnections, ...).
+func jsonString(t *testing.T, data string, out any) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(data), out); err != nil {
+		t.Fatalf("decode %q: %v", data, err)
+	}
+}
+
+// jsonPayload encodes v for methods whose data is a JSON document passed as a string.
+func jsonPayload(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// writeFile writes content under the harness home dir and returns the full path.
+func (h *harness) writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(h.home, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// directTunnel is a C.Tunnel that connects inbound proxy traffic straight to its destination,
+// so a mihomo inbound listener can act as a standalone local proxy server.
+type directTunnel struct{}
+
+// HandleTCPConn implements C.Tunnel.
+func (directTunnel) HandleTCPConn(conn net.Conn, metadata *C.Metadata) {
+	defer conn.Close()
+	remote, err := net.DialTimeout("tcp", metadata.RemoteAddress(), 5*time.Second)
+	if err != nil {
+		return
+	}
+	defer remote.Close()
+	N.Relay(conn, remote)
+}
+
+// HandleUDPPacket implements C.Tunnel; UDP is not needed by the suite.
+func (directTunnel) HandleUDPPacket(packet C.UDPPacket, _ *C.Metadata) {
+	packet.Drop()
+}
+
+// NatTable implements C.Tunnel.
+func (directTunnel) NatTable() C.NatTable {
+	return nil
+}
+
+// localServers are the network stand-ins used by the suite.
+type localServers struct {
+	target      *httptest.Server
+	socksAddr   string
+	httpAddr    string
+	ssAddr      string
+	deadAddr    string
+	hangRelease chan struct{}
+}
+
+// targetURL returns a URL on the local test target.
+func (s *localServers) targetURL(path string) string {
+	return s.target.URL + path
+}
+
+// startLocalServers starts the URL-test target and SOCKS5/HTTP/Shadowsocks proxies.
+func startLocalServers(t *testing.T) *localServers {
+	t.Helper()
+	s := &localServers{hangRelease: make(chan struct{})}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/generate_204", func(w http.ResponseWriter, _ *http.Request) {
+		// Loopback round trips are sub-millisecond, and a 0ms delay is reported as a failure.
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/junk", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("not a database ", 64)))
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(make([]byte, largeBodySize))
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		// An endless body at a bounded rate, for measurements that end with their duration.
+		chunk := make([]byte, 16<<10)
+		for {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-s.hangRelease:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/hang", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-s.hangRelease:
+		}
+	})
+	s.target = httptest.NewServer(mux)
+	t.Cleanup(func() {
+		close(s.hangRelease)
+		s.target.Close()
+	})
+
+	// mihomo's socks and http listeners check the package-global inbound IP filters, which the core
+	// rewrites on every apply, so these two are self-contained.
+	s.socksAddr = serveSOCKS5(t)
+	s.httpAddr = serveHTTPProxy(t)
+
+	ssListener, err := sing_shadowsocks.New(LC.ShadowsocksServer{
+		Enable:   true,
+		Listen:   "127.0.0.1:0",
+		Password: ssPassword,
+		Cipher:   ssCipher,
+	}, directTunnel{})
+	if err != nil {
+		t.Fatalf("start shadowsocks: %v", err)
+	}
+	t.Cleanup(func() { _ = ssListener.Close() })
+	s.ssAddr = ssListener.AddrList()[0].String()
+
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.deadAddr = dead.Addr().String()
+	_ = dead.Close()
+
+	return s
+}
+
+// serveSOCKS5 starts a SOCKS5 server without authentication that supports CONNECT, and returns its address.
+func serveSOCKS5(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("start socks: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleSOCKS5(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+// handleSOCKS5 serves one SOCKS5 client and relays its CONNECT request.
+func handleSOCKS5(conn net.Conn) {
+	defer conn.Close()
+	reply := func(status byte) error {
+		_, err := conn.Write([]byte{5, status, 0, 1, 0, 0, 0, 0, 0, 0})
+		return err
+	}
+
+	// Greeting: version, method count and methods; "no authentication" is always chosen.
+	buf := make([]byte, 256)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != 5 {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return
+	}
+
+	// Request: version, command, reserved, address type, address and port.
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	command := buf[1]
+	var host string
+	switch buf[3] {
+	case 1, 4:
+		size := net.IPv4len
+		if buf[3] == 4 {
+			size = net.IPv6len
+		}
+		if _, err := io.ReadFull(conn, buf[:size]); err != nil {
+			return
+		}
+		host = net.IP(buf[:size]).String()
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return
+		}
+		size := int(buf[0])
+		if _, err := io.ReadFull(conn, buf[:size]); err != nil {
+			return
+		}
+		host = string(buf[:size])
+	default:
+		_ = reply(8)
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	port := strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2])))
+	if command != 1 {
+		_ = reply(7)
+		return
+	}
+
+	remote, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), 5*time.Second)
+	if err != nil {
+		_ = reply(5)
+		return
+	}
+	defer remote.Close()
+	if reply(0) != nil {
+		return
+	}
+	N.Relay(conn, remote)
+}
+
+// serveHTTPProxy starts an HTTP proxy that supports CONNECT, and returns its address.
+func serveHTTPProxy(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		remote, err := net.DialTimeout("tcp", r.Host, 5*time.Second)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer remote.Close()
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+			return
+		}
+		// The client may have sent data right behind the request.
+		if buffered := rw.Reader.Buffered(); buffered > 0 {
+			data, _ := rw.Reader.Peek(buffered)
+			if _, err := remote.Write(data); err != nil {
+				return
+			}
+		}
+		N.Relay(conn, remote)
+	}))
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().String()
+}
+
+// splitHostPort returns host and port of addr for YAML configs.
+func splitHostPort(t *testing.T, addr string) (string, string) {
+	t.Helper()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host, port
+}
+
+// deadProfile renders a config that routes all traffic through a proxy nobody listens on.
+func (s *localServers) deadProfile(t *testing.T) string {
+	t.Helper()
+	deadHost, deadPort := splitHostPort(t, s.deadAddr)
+	return fmt.Sprintf(`mode: rule
+proxies:
+  - {name: dead, type: socks5, server: %s, port: %s}
+rules:
+  - MATCH,dead
+`, deadHost, deadPort)
+}
+
+// profile renders a config whose proxies point at the local servers.
+func (s *localServers) profile(t *testing.T) string {
+	t.Helper()
+	socksHost, socksPort := splitHostPort(t, s.socksAddr)
+	httpHost, httpPort := splitHostPort(t, s.httpAddr)
+	ssHost, ssPort := splitHostPort(t, s.ssAddr)
+	deadHost, deadPort := splitHostPort(t, s.deadAddr)
+
+	return fmt.Sprintf(`mixed-port: 0
+mode: rule
+log-level: info
+ipv6: false
+geox-url:
+  mmdb: %[1]s/hang
+  geoip: %[1]s/junk
+  geosite: %[1]s/junk
+  asn: %[1]s/hang
+proxies:
+  - {name: local-socks, type: socks5, server: %[2]s, port: %[3]s}
+  - {name: local-http, type: http, server: %[4]s, port: %[5]s}
+  - {name: local-ss, type: ss, server: %[6]s, port: %[7]s, cipher: %[8]s, password: %[9]s}
+  - {name: dead, type: socks5, server: %[10]s, port: %[11]s}
+proxy-providers:
+  file-proxies:
+    type: file
+    path: ./providers/proxies.yaml
+rule-providers:
+  file-rules:
+    type: file
+    behavior: domain
+    format: yaml
+    path: ./providers/rules.yaml
+proxy-groups:
+  - name: Proxy
+    type: select
+    proxies: [local-socks, local-http, local-ss, dead]
+    use: [file-proxies]
+rules:
+  - RULE-SET,file-rules,Proxy
+  - MATCH,DIRECT
+`, s.target.URL, socksHost, socksPort, httpHost, httpPort, ssHost, ssPort, ssCipher, ssPassword, deadHost, deadPort)
+}
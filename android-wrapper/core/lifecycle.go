@@ -0,0 +1,121 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"mihomo_android_wrapper/contract"
+)
+
+// lifecycle tracks the core state machine. Transitions are driven by handlers that hold coreMu;
+// stateMu only protects the fields so getState and TUN notifications never wait on coreMu.
+var (
+	stateMu sync.Mutex
+	state   = contract.StateInfo{State: contract.StateUninitialized}
+	// resumeState is the state restored by suspend(false).
+	resumeState contract.State
+)
+
+// configuredStates are the states in which a config has been applied.
+var configuredStates = []contract.State{
+	contract.StateRunning,
+	contract.StateListenersStopped,
+	contract.StateSuspended,
+}
+
+// initializedStates are the states in which initClash has completed.
+var initializedStates = append([]contract.State{contract.StateInitialized}, configuredStates...)
+
+// currentState returns the current lifecycle state.
+func currentState() contract.State {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return state.State
+}
+
+// handleGetState returns the current lifecycle state.
+func handleGetState() contract.StateInfo {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return state
+}
+
+// requireState returns nil if the core is in one of allowed, errNotInitialized if it is uninitialized,
+// or ErrInvalidState otherwise.
+func requireState(allowed ...contract.State) error {
+	current := currentState()
+	for _, s := range allowed {
+		if s == current {
+			return nil
+		}
+	}
+	if current == contract.StateUninitialized {
+		return errNotInitialized
+	}
+	return contract.Errorf(contract.ErrInvalidState, "not allowed in state %s", current).
+		WithDetails(map[string]any{"state": current, "allowed": allowed})
+}
+
+// enterState moves to next and emits a StateMessage; it is a no-op if the state does not change.
+func enterState(next contract.State, reason string) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if state.State == next {
+		return
+	}
+	if next == contract.StateSuspended {
+		resumeState = state.State
+	}
+	state.Previous = state.State
+	state.State = next
+	state.Reason = reason
+	emitStateLocked()
+}
+
+// enterConfiguredState moves to next, or records it as the resume state while suspended.
+func enterConfiguredState(next contract.State, reason string) {
+	stateMu.Lock()
+	if state.State == contract.StateSuspended {
+		resumeState = next
+		stateMu.Unlock()
+		return
+	}
+	stateMu.Unlock()
+	enterState(next, reason)
+}
+
+// suspendedFrom returns the state to restore when leaving StateSuspended.
+func suspendedFrom() contract.State {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if resumeState == "" {
+		return contract.StateRunning
+	}
+	return resumeState
+}
+
+// setTunUp records whether the host TUN device is up and emits a StateMessage on change.
+func setTunUp(up bool) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if state.TunUp == up {
+		return
+	}
+	state.TunUp = up
+	state.Previous = state.State
+	if up {
+		state.Reason = "tun started"
+	} else {
+		state.Reason = "tun stopped"
+	}
+	emitStateLocked()
+}
+
+// emitStateLocked stamps and emits the current state (requires stateMu, so events keep transition order).
+func emitStateLocked() {
+	state.Since = time.Now().UnixMilli()
+	emitMessage(contract.Message{
+		Type: contract.StateMessage,
+		Data: state,
+	})
+}
@@ -1,46 +1,60 @@
-//go:build android && cgo
-
 package core
 
 import (
+	"mihomo_android_wrapper/contract"
+
 	"github.com/metacubex/mihomo/component/resolver"
-	"github.com/metacubex/mihomo/hub"
-	"github.com/metacubex/mihomo/hub/executor"
 	"github.com/metacubex/mihomo/hub/route"
 	"github.com/metacubex/mihomo/listener"
 	LC "github.com/metacubex/mihomo/listener/config"
 	"github.com/metacubex/mihomo/tunnel"
 )
 
-// handleStartListener reapplies the current config and recreates inbound listeners.
-func handleStartListener() bool {
+// handleStartListener reapplies the current config, with its updateConfig patches, and recreates
+// inbound listeners.
+func handleStartListener() error {
 	coreMu.Lock()
 	defer coreMu.Unlock()
 
-	if !isInit {
-		return false
+	if err := requireState(configuredStates...); err != nil {
+		return err
 	}
 
-	cfg, err := executor.Parse()
+	data, patches, err := replayConfig(nil)
 	if err != nil {
-		return false
+		return err
 	}
-
-	// See handleUpdateConfig: Android provides the VPN fd via startTUN().
-	if cfg.General != nil {
-		cfg.General.Tun.Enable = false
+	cfg, err := parseConfigBytes(data)
+	if err != nil {
+		return err
 	}
 
-	hub.ApplyConfig(cfg)
+	applyConfig(cfg, currentSelections())
 	resolver.ResetConnection()
-	return true
+	if appliedConfig != nil {
+		appliedConfig = &configSnapshot{data: data, source: appliedConfig.source, selected: appliedConfig.selected, patches: patches}
+	}
+	enterConfiguredState(contract.StateRunning, "startListener")
+	return nil
 }
 
 // handleStopListener stops all inbound listeners without stopping the core process.
-func handleStopListener() bool {
+func handleStopListener() error {
 	coreMu.Lock()
 	defer coreMu.Unlock()
 
+	if err := requireState(configuredStates...); err != nil {
+		return err
+	}
+
+	stopInboundListeners()
+	resolver.ResetConnection()
+	enterConfiguredState(contract.StateListenersStopped, "stopListener")
+	return nil
+}
+
+// stopInboundListeners closes every inbound listener and the external controller.
+func stopInboundListeners() {
 	// Stop common inbound listeners.
 	listener.ReCreateHTTP(0, tunnel.Tunnel)
 	listener.ReCreateSocks(0, tunnel.Tunnel)
@@ -54,6 +68,4 @@ func handleStopListener() bool {
 	listener.Cleanup()
 
 	route.ReCreateServer(&route.Config{})
-	resolver.ResetConnection()
-	return true
 }
@@ -1,5 +1,3 @@
-//go:build android && cgo
-
 package core
 
 import (
@@ -40,13 +38,15 @@ func handleStartLog() {
 	}()
 }
 
-// handleStopLog stops forwarding log events to the host.
-func handleStopLog() {
+// handleStopLog stops forwarding log events to the host; it reports whether the stream was running.
+func handleStopLog() bool {
 	logMu.Lock()
 	sub := logSubscriber
 	logSubscriber = nil
 	logMu.Unlock()
-	if sub != nil {
-		log.UnSubscribe(sub)
+	if sub == nil {
+		return false
 	}
+	log.UnSubscribe(sub)
+	return true
 }
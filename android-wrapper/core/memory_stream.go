@@ -1,5 +1,3 @@
-//go:build android && cgo
-
 package core
 
 import (
@@ -43,19 +41,20 @@ func handleStartMemory() {
 	}()
 }
 
-// handleStopMemory stops periodic memory usage reporting.
-func handleStopMemory() {
+// handleStopMemory stops periodic memory usage reporting; it reports whether the stream was running.
+func handleStopMemory() bool {
 	memoryMu.Lock()
 	defer memoryMu.Unlock()
 
 	if memoryTicker == nil {
-		return
+		return false
 	}
 
 	memoryTicker.Stop()
 	close(memoryStopChan)
 	memoryTicker = nil
 	memoryStopChan = nil
+	return true
 }
 
 func emitMemoryData() {
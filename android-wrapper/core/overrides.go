@@ -0,0 +1,159 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/log"
+)
+
+// overridesFile is the name of the persisted overlay under the home dir.
+const overridesFile = "overrides.json"
+
+// overrides is the host overlay loaded by initClash and merged into every parse; guarded by coreMu.
+var overrides contract.Overrides
+
+func overridesPath() string {
+	return filepath.Join(constant.Path.HomeDir(), overridesFile)
+}
+
+// loadOverrides reads the overlay from the home dir; a missing file means no overlay.
+func loadOverrides() error {
+	overrides = contract.Overrides{}
+
+	path := overridesPath()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fileError(err, path)
+	}
+
+	var loaded contract.Overrides
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return contract.WrapError(contract.ErrConfigParse, err).WithDetails(map[string]string{"path": path})
+	}
+	overrides = loaded
+	return nil
+}
+
+// handleGetOverrides returns the current overlay.
+func handleGetOverrides() (contract.Overrides, error) {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if err := requireState(initializedStates...); err != nil {
+		return contract.Overrides{}, err
+	}
+
+	current := overrides
+	if current.Patch == nil {
+		current.Patch = map[string]any{}
+	}
+	if current.PrependRules == nil {
+		current.PrependRules = []string{}
+	}
+	return current, nil
+}
+
+// handleSetOverrides replaces and persists the overlay. It takes effect on the next setupConfig,
+// updateConfig or startListener; if a config is applied, it must still parse with the new overlay.
+func handleSetOverrides(params contract.Overrides) error {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if err := requireState(initializedStates...); err != nil {
+		return err
+	}
+	if appliedConfig != nil {
+		if _, err := parseWithOverrides(appliedConfig.data, params); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return invalidParams(err)
+	}
+
+	if err := writeFileAtomic(overridesPath(), data); err != nil {
+		return err
+	}
+
+	overrides = params
+	log.Infoln("[Config] host overrides updated")
+	return nil
+}
+
+// handleClearOverrides removes the overlay; like setOverrides, it takes effect on the next parse.
+func handleClearOverrides() error {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if err := requireState(initializedStates...); err != nil {
+		return err
+	}
+
+	path := overridesPath()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fileError(err, path)
+	}
+	overrides = contract.Overrides{}
+	log.Infoln("[Config] host overrides cleared")
+	return nil
+}
+
+// mergeOverrides returns data with o merged in. Without an overlay data is returned unchanged;
+// otherwise the result is the merged document encoded as JSON, which mihomo parses as YAML.
+func mergeOverrides(data []byte, o contract.Overrides) ([]byte, error) {
+	if len(o.Patch) == 0 && len(o.PrependRules) == 0 {
+		return data, nil
+	}
+
+	profile, err := decodeConfigDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	merged, _ := mergePatch(profile, o.Patch).(map[string]any)
+
+	if len(o.PrependRules) > 0 {
+		rules, _ := merged["rules"].([]any)
+		prepended := make([]any, 0, len(o.PrependRules)+len(rules))
+		for _, rule := range o.PrependRules {
+			prepended = append(prepended, rule)
+		}
+		merged["rules"] = append(prepended, rules...)
+	}
+
+	out, err := json.Marshal(merged)
+	if err != nil {
+		return nil, contract.WrapError(contract.ErrConfigParse, err)
+	}
+	return out, nil
+}
+
+// mergePatch applies a JSON merge patch (RFC 7396) to target, modifying target's objects in place.
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any, len(patchObject))
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+	return targetObject
+}
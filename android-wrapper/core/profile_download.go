@@ -0,0 +1,375 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mihomo_android_wrapper/contract"
+
+	mihomoHttp "github.com/metacubex/mihomo/component/http"
+	"github.com/metacubex/mihomo/log"
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+const (
+	// profileDownloadTimeout bounds a single profile download.
+	profileDownloadTimeout = 60 * time.Second
+	// defaultProfileMaxSize is used when RemoteProfile.MaxSize is not set.
+	defaultProfileMaxSize = 10 << 20
+)
+
+// refreshTimers holds the pending background refresh of every profile with a refresh interval.
+var (
+	refreshMu     sync.Mutex
+	refreshTimers = make(map[string]*time.Timer)
+)
+
+// fetchedProfile is the outcome of a profile download; data is nil when the server answered 304.
+type fetchedProfile struct {
+	data         []byte
+	etag         string
+	lastModified string
+	subscription *contract.SubscriptionInfo
+}
+
+// handleDownloadProfile downloads a profile and stores it as a new profile, or refreshes the profile
+// params.ID. The download runs without coreMu, so other actions are not blocked by a slow server;
+// the content is validated like createProfile before it replaces anything. Refreshing the active
+// profile does not re-apply it; the host calls activateProfile when Changed is set.
+func handleDownloadProfile(ctx context.Context, params contract.DownloadProfileParams) (contract.ProfileDownload, error) {
+	coreMu.Lock()
+	remote, err := remoteOptions(params)
+	coreMu.Unlock()
+	if err != nil {
+		return contract.ProfileDownload{}, err
+	}
+
+	taskCtx, end, err := beginTask(ctx, initializedStates...)
+	if err != nil {
+		return contract.ProfileDownload{}, err
+	}
+	defer end()
+
+	fetched, err := fetchProfile(taskCtx, remote)
+	if err != nil {
+		return contract.ProfileDownload{}, err
+	}
+
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if err := requireState(initializedStates...); err != nil {
+		return contract.ProfileDownload{}, err
+	}
+	return storeDownloadedProfile(params, remote, fetched)
+}
+
+// remoteOptions returns the download options for params: those stored with the profile being
+// refreshed, with the set fields of params on top. The caller holds coreMu.
+func remoteOptions(params contract.DownloadProfileParams) (contract.RemoteProfile, error) {
+	if err := requireState(initializedStates...); err != nil {
+		return contract.RemoteProfile{}, err
+	}
+
+	var remote contract.RemoteProfile
+	if params.ID != "" {
+		i, err := findProfile(params.ID)
+		if err != nil {
+			return contract.RemoteProfile{}, err
+		}
+		if stored := profiles.Profiles[i].Remote; stored != nil {
+			remote = *stored
+		} else if params.URL == "" {
+			return contract.RemoteProfile{}, contract.Errorf(contract.ErrInvalidParams, "profile %q was not downloaded; missing url", params.ID)
+		}
+		// Conditional requests only make sense while the stored content is still there.
+		if _, err := os.Stat(profilePath(params.ID)); err != nil {
+			remote.ETag, remote.LastModified = "", ""
+		}
+	}
+
+	if params.URL != "" && params.URL != remote.URL {
+		remote.URL = params.URL
+		remote.ETag, remote.LastModified = "", ""
+	}
+	if params.UserAgent != "" {
+		remote.UserAgent = params.UserAgent
+	}
+	if params.Headers != nil {
+		remote.Headers = params.Headers
+	}
+	if params.Proxy != "" {
+		remote.Proxy = params.Proxy
+	}
+	if params.MaxSize > 0 {
+		remote.MaxSize = params.MaxSize
+	}
+	if params.RefreshInterval != nil {
+		remote.RefreshInterval = *params.RefreshInterval
+	}
+
+	if remote.Proxy != "" {
+		if _, ok := tunnel.Proxies()[remote.Proxy]; !ok {
+			return contract.RemoteProfile{}, contract.Errorf(contract.ErrNotFound, "proxy %q not found", remote.Proxy)
+		}
+	}
+	return remote, nil
+}
+
+// fetchProfile downloads remote.URL, sending the stored validators so an unchanged profile costs a 304.
+func fetchProfile(ctx context.Context, remote contract.RemoteProfile) (fetchedProfile, error) {
+	ctx, cancel := context.WithTimeout(ctx, profileDownloadTimeout)
+	defer cancel()
+
+	header := make(map[string][]string)
+	for key, value := range remote.Headers {
+		header[key] = []string{value}
+	}
+	if remote.UserAgent != "" {
+		header["User-Agent"] = []string{remote.UserAgent}
+	}
+	if remote.ETag != "" {
+		header["If-None-Match"] = []string{remote.ETag}
+	}
+	if remote.LastModified != "" {
+		header["If-Modified-Since"] = []string{remote.LastModified}
+	}
+	proxy := remote.Proxy
+	if proxy == "" {
+		proxy = "DIRECT"
+	}
+
+	resp, err := mihomoHttp.HttpRequest(ctx, remote.URL, http.MethodGet, header, nil, mihomoHttp.WithSpecialProxy(proxy))
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fetchedProfile{}, ctxErr
+		}
+		return fetchedProfile{}, contract.Errorf(contract.ErrIO, "can't download profile: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	fetched := fetchedProfile{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		subscription: parseSubscriptionInfo(resp.Header.Get("Subscription-Userinfo")),
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return fetched, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fetchedProfile{}, contract.Errorf(contract.ErrIO, "can't download profile: HTTP %s", resp.Status).
+			WithDetails(map[string]int{"status": resp.StatusCode})
+	}
+
+	maxSize := remote.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultProfileMaxSize
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fetchedProfile{}, ctxErr
+		}
+		return fetchedProfile{}, contract.Errorf(contract.ErrIO, "can't download profile: %s", err.Error())
+	}
+	if int64(len(data)) > maxSize {
+		return fetchedProfile{}, contract.Errorf(contract.ErrIO, "profile exceeds the size limit of %d bytes", maxSize).
+			WithDetails(map[string]int64{"max-size": maxSize})
+	}
+	if len(data) == 0 {
+		return fetchedProfile{}, contract.NewError(contract.ErrConfigParse, "downloaded profile is empty")
+	}
+	fetched.data = data
+	return fetched, nil
+}
+
+// parseSubscriptionInfo parses a "upload=1; download=2; total=3; expire=4" header; it returns nil when absent.
+func parseSubscriptionInfo(value string) *contract.SubscriptionInfo {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	info := &contract.SubscriptionInfo{}
+	for _, field := range strings.Split(value, ";") {
+		key, raw, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found {
+			continue
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "upload":
+			info.Upload = int64(number)
+		case "download":
+			info.Download = int64(number)
+		case "total":
+			info.Total = int64(number)
+		case "expire":
+			info.Expire = int64(number)
+		}
+	}
+	return info
+}
+
+// storeDownloadedProfile stores fetched as a new profile or as the new content of params.ID and
+// re-arms its background refresh. The caller holds coreMu.
+func storeDownloadedProfile(params contract.DownloadProfileParams, remote contract.RemoteProfile, fetched fetchedProfile) (contract.ProfileDownload, error) {
+	now := time.Now().UnixMilli()
+	remote.FetchedAt = now
+	if fetched.data != nil || fetched.etag != "" {
+		remote.ETag = fetched.etag
+	}
+	if fetched.data != nil || fetched.lastModified != "" {
+		remote.LastModified = fetched.lastModified
+	}
+	if fetched.subscription != nil {
+		remote.Subscription = fetched.subscription
+	}
+
+	if params.ID == "" {
+		name := params.Name
+		if name == "" {
+			if u, err := url.Parse(remote.URL); err == nil {
+				name = u.Hostname()
+			}
+		}
+		profile, err := addProfile(name, &remote, fetched.data)
+		if err != nil {
+			return contract.ProfileDownload{}, err
+		}
+		scheduleProfileRefresh(profile)
+		return contract.ProfileDownload{Profile: profile, Changed: true}, nil
+	}
+
+	// The profile may have been deleted while the download ran.
+	i, err := findProfile(params.ID)
+	if err != nil {
+		return contract.ProfileDownload{}, err
+	}
+	index := cloneProfiles()
+	profile := &index.Profiles[i]
+
+	changed := false
+	if fetched.data != nil {
+		if current, err := os.ReadFile(profile.Path); err != nil || !bytes.Equal(current, fetched.data) {
+			if _, err := parseConfigBytes(fetched.data); err != nil {
+				return contract.ProfileDownload{}, err
+			}
+			if err := writeFileAtomic(profile.Path, fetched.data); err != nil {
+				return contract.ProfileDownload{}, err
+			}
+			profile.UpdatedAt = now
+			changed = true
+		}
+	}
+	if params.Name != "" {
+		profile.Name = params.Name
+	}
+	profile.Remote = &remote
+
+	if err := saveProfiles(index); err != nil {
+		return contract.ProfileDownload{}, err
+	}
+	scheduleProfileRefresh(*profile)
+	if changed {
+		log.Infoln("[Profile] downloaded new content for %s (%s)", profile.ID, profile.Name)
+	}
+	return contract.ProfileDownload{Profile: withSelections(*profile), Changed: changed}, nil
+}
+
+// scheduleProfileRefresh arms the background refresh of profile one interval after its last
+// download, or stops it when the profile has no refresh interval.
+func scheduleProfileRefresh(profile contract.Profile) {
+	if profile.Remote == nil || profile.Remote.RefreshInterval <= 0 {
+		stopProfileRefresh(profile.ID)
+		return
+	}
+	interval := time.Duration(profile.Remote.RefreshInterval) * time.Second
+	armProfileRefresh(profile.ID, time.Until(time.UnixMilli(profile.Remote.FetchedAt).Add(interval)))
+}
+
+// armProfileRefresh replaces the pending refresh of id with one after delay.
+func armProfileRefresh(id string, delay time.Duration) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	if timer := refreshTimers[id]; timer != nil {
+		timer.Stop()
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	refreshTimers[id] = time.AfterFunc(delay, func() { refreshProfileInBackground(id) })
+}
+
+// stopProfileRefresh cancels the pending refresh of id, if any.
+func stopProfileRefresh(id string) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	if timer := refreshTimers[id]; timer != nil {
+		timer.Stop()
+		delete(refreshTimers, id)
+	}
+}
+
+// stopProfileRefreshes cancels every pending refresh, e.g. on shutdown.
+func stopProfileRefreshes() {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	for id, timer := range refreshTimers {
+		timer.Stop()
+		delete(refreshTimers, id)
+	}
+}
+
+// refreshProfileInBackground re-downloads a profile when its refresh interval elapses and reports
+// changes and failures as a ProfileMessage. A failed refresh is retried one interval later.
+func refreshProfileInBackground(id string) {
+	result, err := handleDownloadProfile(context.Background(), contract.DownloadProfileParams{ID: id})
+	if err != nil {
+		var coreErr *contract.Error
+		if errors.Is(err, context.Canceled) || (errors.As(err, &coreErr) &&
+			(coreErr.Code == contract.ErrNotInitialized || coreErr.Code == contract.ErrInvalidState)) {
+			// The core is shutting down.
+			return
+		}
+
+		coreMu.Lock()
+		i := profileIndexOf(id)
+		if i >= 0 && profiles.Profiles[i].Remote != nil && profiles.Profiles[i].Remote.RefreshInterval > 0 {
+			armProfileRefresh(id, time.Duration(profiles.Profiles[i].Remote.RefreshInterval)*time.Second)
+		}
+		coreMu.Unlock()
+		if i < 0 {
+			// The profile was deleted while the download ran.
+			return
+		}
+
+		log.Warnln("[Profile] background refresh of %s failed: %s", id, err.Error())
+
+		emitMessage(contract.Message{
+			Type: contract.ProfileMessage,
+			Data: contract.ProfileRefresh{ID: id, Error: err.Error()},
+		})
+		return
+	}
+
+	if result.Changed {
+		emitMessage(contract.Message{
+			Type: contract.ProfileMessage,
+			Data: contract.ProfileRefresh{ID: id, Changed: true},
+		})
+	}
+}
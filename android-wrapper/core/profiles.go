@@ -0,0 +1,324 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/log"
+)
+
+const (
+	// profilesDir holds the profile configs and their index under the home dir.
+	profilesDir = "profiles"
+	// profileIndexFile is the name of the persisted profile index in profilesDir.
+	profileIndexFile = "profiles.json"
+)
+
+// profileIndex is the persisted profile list. Active is the profile activated last; initClash points
+// the default config path at it, so a file-mode setupConfig without config-path loads it.
+type profileIndex struct {
+	Active   string             `json:"active"`
+	Profiles []contract.Profile `json:"profiles"`
+}
+
+// profiles is the index loaded by initClash; guarded by coreMu.
+var profiles profileIndex
+
+func profilePath(id string) string {
+	return filepath.Join(constant.Path.HomeDir(), profilesDir, id+".yaml")
+}
+
+func profileIndexPath() string {
+	return filepath.Join(constant.Path.HomeDir(), profilesDir, profileIndexFile)
+}
+
+// loadProfiles reads the profile index from the home dir; a missing index means no profiles.
+func loadProfiles() error {
+	profiles = profileIndex{}
+	stopProfileRefreshes()
+
+	path := profileIndexPath()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fileError(err, path)
+	}
+
+	var loaded profileIndex
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return contract.WrapError(contract.ErrConfigParse, err).WithDetails(map[string]string{"path": path})
+	}
+	for i := range loaded.Profiles {
+		loaded.Profiles[i].Path = profilePath(loaded.Profiles[i].ID)
+		// Older indexes stored selections; they are kept in selections.json now.
+		loaded.Profiles[i].Selected = nil
+	}
+	profiles = loaded
+	for _, profile := range profiles.Profiles {
+		scheduleProfileRefresh(profile)
+	}
+
+	if profileIndexOf(profiles.Active) < 0 {
+		profiles.Active = ""
+	} else if _, err := os.Stat(profilePath(profiles.Active)); err == nil {
+		constant.SetConfig(profilePath(profiles.Active))
+	}
+	return nil
+}
+
+// saveProfiles persists index and makes it the current one.
+func saveProfiles(index profileIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return contract.WrapError(contract.ErrInternal, err)
+	}
+	if err := writeFileAtomic(profileIndexPath(), data); err != nil {
+		return err
+	}
+	profiles = index
+	return nil
+}
+
+// cloneProfiles returns a copy of the index whose profile list can be modified without touching profiles.
+func cloneProfiles() profileIndex {
+	return profileIndex{
+		Active:   profiles.Active,
+		Profiles: append([]contract.Profile(nil), profiles.Profiles...),
+	}
+}
+
+// profileIndexOf returns the position of the profile with id, or -1.
+func profileIndexOf(id string) int {
+	for i, profile := range profiles.Profiles {
+		if profile.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// findProfile returns the position of the profile with id, or ErrNotFound.
+func findProfile(id string) (int, error) {
+	i := profileIndexOf(id)
+	if i < 0 {
+		return -1, contract.Errorf(contract.ErrNotFound, "profile %q not found", id)
+	}
+	return i, nil
+}
+
+// withSelections returns profile with the selector choices recorded for its config file.
+func withSelections(profile contract.Profile) contract.Profile {
+	profile.Selected = map[string]string{}
+	for group, proxy := range selections[profile.Path] {
+		profile.Selected[group] = proxy
+	}
+	return profile
+}
+
+// activeProfileID returns the profile the running config was loaded from: the applied config's
+// source, or the default config path when nothing is applied. It is empty for payload configs and
+// files outside the profile store.
+func activeProfileID() string {
+	source := constant.Path.Config()
+	if appliedConfig != nil {
+		source = appliedConfig.source
+	}
+	for _, profile := range profiles.Profiles {
+		if profile.Path == source {
+			return profile.ID
+		}
+	}
+	return ""
+}
+
+// handleListProfiles returns the profiles in creation order, with their recorded selector choices,
+// and the active one.
+func handleListProfiles() (contract.ProfileList, error) {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if err := requireState(initializedStates...); err != nil {
+		return contract.ProfileList{}, err
+	}
+
+	list := contract.ProfileList{Active: activeProfileID(), Profiles: []contract.Profile{}}
+	for _, profile := range profiles.Profiles {
+		list.Profiles = append(list.Profiles, withSelections(profile))
+	}
+	return list, nil
+}
+
+// handleCreateProfile stores inline config content as a new profile.
+func handleCreateProfile(params contract.CreateProfileParams) (contract.Profile, error) {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if err := requireState(initializedStates...); err != nil {
+		return contract.Profile{}, err
+	}
+	return addProfile(params.Name, nil, []byte(params.Payload))
+}
+
+// handleImportProfile copies an existing config file, e.g. one the host downloaded, into a new profile.
+func handleImportProfile(params contract.ImportProfileParams) (contract.Profile, error) {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if err := requireState(initializedStates...); err != nil {
+		return contract.Profile{}, err
+	}
+	data, err := readConfigFile(params.ConfigPath)
+	if err != nil {
+		return contract.Profile{}, err
+	}
+	return addProfile(params.Name, nil, data)
+}
+
+// addProfile validates data and stores it under a new profile id; remote is set for downloaded
+// profiles. The caller holds coreMu.
+func addProfile(name string, remote *contract.RemoteProfile, data []byte) (contract.Profile, error) {
+	if _, err := parseConfigBytes(data); err != nil {
+		return contract.Profile{}, err
+	}
+
+	id, err := newProfileID()
+	if err != nil {
+		return contract.Profile{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(profileIndexPath()), 0o700); err != nil {
+		return contract.Profile{}, fileError(err, filepath.Dir(profileIndexPath()))
+	}
+	path := profilePath(id)
+	if err := writeFileAtomic(path, data); err != nil {
+		return contract.Profile{}, err
+	}
+
+	now := time.Now().UnixMilli()
+	profile := contract.Profile{
+		ID:        id,
+		Name:      name,
+		Path:      path,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Remote:    remote,
+	}
+	index := cloneProfiles()
+	index.Profiles = append(index.Profiles, profile)
+	if err := saveProfiles(index); err != nil {
+		_ = os.Remove(path)
+		return contract.Profile{}, err
+	}
+	log.Infoln("[Profile] created %s (%s)", id, name)
+	return withSelections(profile), nil
+}
+
+// newProfileID returns a random id that is not in use.
+func newProfileID() (string, error) {
+	for {
+		buf := make([]byte, 6)
+		if _, err := rand.Read(buf); err != nil {
+			return "", contract.WrapError(contract.ErrInternal, err)
+		}
+		if id := hex.EncodeToString(buf); profileIndexOf(id) < 0 {
+			return id, nil
+		}
+	}
+}
+
+// handleRenameProfile changes the display name of a profile.
+func handleRenameProfile(params contract.RenameProfileParams) error {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if err := requireState(initializedStates...); err != nil {
+		return err
+	}
+	i, err := findProfile(params.ID)
+	if err != nil {
+		return err
+	}
+
+	index := cloneProfiles()
+	index.Profiles[i].Name = params.Name
+	return saveProfiles(index)
+}
+
+// handleDeleteProfile removes a profile and its config file. The active profile cannot be deleted.
+func handleDeleteProfile(id string) error {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if err := requireState(initializedStates...); err != nil {
+		return err
+	}
+	if id == "" {
+		return missingParam("id")
+	}
+	i, err := findProfile(id)
+	if err != nil {
+		return err
+	}
+	if id == activeProfileID() {
+		return contract.Errorf(contract.ErrInvalidState, "profile %q is active; activate another profile first", id)
+	}
+
+	index := cloneProfiles()
+	index.Profiles = append(index.Profiles[:i], index.Profiles[i+1:]...)
+	if index.Active == id {
+		index.Active = ""
+	}
+	if err := saveProfiles(index); err != nil {
+		return err
+	}
+	stopProfileRefresh(id)
+	forgetSelections(profilePath(id))
+	if err := os.Remove(profilePath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warnln("[Profile] failed to remove config of deleted profile %s: %s", id, err.Error())
+	}
+	log.Infoln("[Profile] deleted %s", id)
+	return nil
+}
+
+// handleActivateProfile applies a profile through the setupConfig path, which restores the selector
+// choices recorded for it.
+func handleActivateProfile(ctx context.Context, params contract.ActivateProfileParams) error {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if err := requireState(initializedStates...); err != nil {
+		return err
+	}
+	i, err := findProfile(params.ID)
+	if err != nil {
+		return err
+	}
+
+	err = setupConfig(ctx, SetupParams{
+		ConfigPath:   profilePath(params.ID),
+		TestURL:      params.TestURL,
+		Probe:        params.Probe,
+		ProbeTimeout: params.ProbeTimeout,
+	})
+	if err != nil {
+		return err
+	}
+
+	index := cloneProfiles()
+	index.Active = params.ID
+	if err := saveProfiles(index); err != nil {
+		return err
+	}
+	log.Infoln("[Profile] activated %s (%s)", params.ID, index.Profiles[i].Name)
+	return nil
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/log"
+)
+
+// selectionsFile is the name of the persisted selector choices under the home dir.
+const selectionsFile = "selections.json"
+
+// selections maps a config file path to the selector choices last seen for it. Payload configs
+// have no stable identity and are not recorded. Guarded by coreMu.
+var selections map[string]map[string]string
+
+func selectionsPath() string {
+	return filepath.Join(constant.Path.HomeDir(), selectionsFile)
+}
+
+// loadSelections reads the recorded choices from the home dir; a missing file means none.
+func loadSelections() error {
+	selections = map[string]map[string]string{}
+
+	path := selectionsPath()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fileError(err, path)
+	}
+
+	var loaded map[string]map[string]string
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return contract.WrapError(contract.ErrConfigParse, err).WithDetails(map[string]string{"path": path})
+	}
+	if loaded != nil {
+		selections = loaded
+	}
+	return nil
+}
+
+// saveSelections persists the recorded choices.
+func saveSelections() error {
+	data, err := json.MarshalIndent(selections, "", "  ")
+	if err != nil {
+		return contract.WrapError(contract.ErrInternal, err)
+	}
+	return writeFileAtomic(selectionsPath(), data)
+}
+
+// storedSelections returns the choices recorded for source overlaid with selected, which wins.
+func storedSelections(source string, selected map[string]string) map[string]string {
+	stored := selections[source]
+	if len(stored) == 0 {
+		return selected
+	}
+	merged := make(map[string]string, len(stored)+len(selected))
+	for group, proxy := range stored {
+		merged[group] = proxy
+	}
+	for group, proxy := range selected {
+		merged[group] = proxy
+	}
+	return merged
+}
+
+// recordSelections stores the current choices of the applied config file if they changed. Failing to
+// persist them is logged, not returned, so it never fails the call that triggered it.
+func recordSelections() {
+	if appliedConfig == nil || appliedConfig.source == "payload" {
+		return
+	}
+	current := currentSelections()
+	if reflect.DeepEqual(selections[appliedConfig.source], current) {
+		return
+	}
+	if selections == nil {
+		selections = map[string]map[string]string{}
+	}
+	selections[appliedConfig.source] = current
+	if err := saveSelections(); err != nil {
+		log.Warnln("[Selection] failed to persist selections: %s", err.Error())
+	}
+}
+
+// forgetSelections drops the choices recorded for source, e.g. for a deleted profile.
+func forgetSelections(source string) {
+	if _, ok := selections[source]; !ok {
+		return
+	}
+	delete(selections, source)
+	if err := saveSelections(); err != nil {
+		log.Warnln("[Selection] failed to persist selections: %s", err.Error())
+	}
+}
+
+// handleGetSelections returns the current choice of every Selector group.
+func handleGetSelections() (map[string]string, error) {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if err := requireState(configuredStates...); err != nil {
+		return nil, err
+	}
+	return currentSelections(), nil
+}
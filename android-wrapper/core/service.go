@@ -1,17 +1,33 @@
-//go:build android && cgo
-
 package core
 
-import "mihomo_android_wrapper/contract"
+import (
+	"context"
+
+	"mihomo_android_wrapper/contract"
+)
+
+// TunController controls a TUN device owned by the host (on Android, the VpnService fd passed to startTUN).
+type TunController interface {
+	StopTun()
+}
+
+// DNSUpdater applies system DNS servers reported by the host; nil servers restore the default.
+type DNSUpdater interface {
+	UpdateSystemDNS(servers []string)
+}
 
 type Options struct {
 	Emitter contract.Emitter
-	StopTun func()
+	// Tun is optional; leave nil when the host does not manage a TUN device.
+	Tun TunController
+	// DNS overrides the platform default (see dns_update_cmfa.go); nil keeps the default.
+	DNS DNSUpdater
 }
 
 var (
-	emitter     contract.Emitter
-	stopTunHook func()
+	emitter       contract.Emitter
+	tunController TunController
+	dnsUpdater    DNSUpdater
 )
 
 // emitMessage emits an event to the host if an Emitter is set.
@@ -27,12 +43,16 @@ type Service struct{}
 // New creates a Service and installs global hooks required by core logic.
 func New(opts Options) *Service {
 	emitter = opts.Emitter
-	stopTunHook = opts.StopTun
+	tunController = opts.Tun
+	dnsUpdater = defaultDNSUpdater
+	if opts.DNS != nil {
+		dnsUpdater = opts.DNS
+	}
 	return &Service{}
 }
 
 // InitClash delegates to handleInitClash.
-func (s *Service) InitClash(params contract.InitParams) bool {
+func (s *Service) InitClash(params contract.InitParams) error {
 	return handleInitClash(params)
 }
 
@@ -41,39 +61,129 @@ func (s *Service) GetVersion() string {
 	return handleGetVersion()
 }
 
+// GetCapabilities delegates to handleGetCapabilities.
+func (s *Service) GetCapabilities() contract.Capabilities {
+	return handleGetCapabilities()
+}
+
 // GetIsInit delegates to handleGetIsInit.
 func (s *Service) GetIsInit() bool {
 	return handleGetIsInit()
 }
 
+// GetState delegates to handleGetState.
+func (s *Service) GetState() contract.StateInfo {
+	return handleGetState()
+}
+
 // ForceGC delegates to handleForceGC.
 func (s *Service) ForceGC() {
 	handleForceGC()
 }
 
 // Shutdown delegates to handleShutdown.
-func (s *Service) Shutdown() bool {
-	return handleShutdown()
+func (s *Service) Shutdown(ctx context.Context, params contract.ShutdownParams) contract.ShutdownSummary {
+	return handleShutdown(ctx, params)
 }
 
 // ValidateConfig delegates to handleValidateConfig.
-func (s *Service) ValidateConfig(path string) string {
+func (s *Service) ValidateConfig(path string) error {
 	return handleValidateConfig(path)
 }
 
+// ValidateConfigDiagnostics delegates to handleValidateConfigDiagnostics.
+func (s *Service) ValidateConfigDiagnostics(params contract.ConfigSourceParams) (contract.ConfigValidation, error) {
+	return handleValidateConfigDiagnostics(params)
+}
+
 // GetConfig delegates to handleGetConfig.
 func (s *Service) GetConfig(path string) (any, error) {
 	return handleGetConfig(path)
 }
 
+// GetEffectiveConfig delegates to handleGetEffectiveConfig.
+func (s *Service) GetEffectiveConfig(params contract.EffectiveConfigParams) (contract.EffectiveConfig, error) {
+	return handleGetEffectiveConfig(params)
+}
+
 // UpdateConfig delegates to handleUpdateConfig.
-func (s *Service) UpdateConfig(payload string) string {
+func (s *Service) UpdateConfig(payload string) (contract.ConfigUpdate, error) {
 	return handleUpdateConfig([]byte(payload))
 }
 
 // SetupConfig delegates to handleSetupConfig.
-func (s *Service) SetupConfig(payload string) string {
-	return handleSetupConfig([]byte(payload))
+func (s *Service) SetupConfig(ctx context.Context, payload string) error {
+	return handleSetupConfig(ctx, []byte(payload))
+}
+
+// RollbackConfig delegates to handleRollbackConfig.
+func (s *Service) RollbackConfig() error {
+	return handleRollbackConfig()
+}
+
+// DiffConfig delegates to handleDiffConfig.
+func (s *Service) DiffConfig(params contract.ConfigSourceParams) (contract.ConfigDiff, error) {
+	return handleDiffConfig(params)
+}
+
+// GetOverrides delegates to handleGetOverrides.
+func (s *Service) GetOverrides() (contract.Overrides, error) {
+	return handleGetOverrides()
+}
+
+// SetOverrides delegates to handleSetOverrides.
+func (s *Service) SetOverrides(params contract.Overrides) error {
+	return handleSetOverrides(params)
+}
+
+// ClearOverrides delegates to handleClearOverrides.
+func (s *Service) ClearOverrides() error {
+	return handleClearOverrides()
+}
+
+// ImportShareLinks delegates to handleImportShareLinks.
+func (s *Service) ImportShareLinks(data string) contract.ShareLinkImport {
+	return handleImportShareLinks(data)
+}
+
+// ExportShareLink delegates to handleExportShareLink.
+func (s *Service) ExportShareLink(name string) (string, error) {
+	return handleExportShareLink(name)
+}
+
+// ListProfiles delegates to handleListProfiles.
+func (s *Service) ListProfiles() (contract.ProfileList, error) {
+	return handleListProfiles()
+}
+
+// CreateProfile delegates to handleCreateProfile.
+func (s *Service) CreateProfile(params contract.CreateProfileParams) (contract.Profile, error) {
+	return handleCreateProfile(params)
+}
+
+// ImportProfile delegates to handleImportProfile.
+func (s *Service) ImportProfile(params contract.ImportProfileParams) (contract.Profile, error) {
+	return handleImportProfile(params)
+}
+
+// RenameProfile delegates to handleRenameProfile.
+func (s *Service) RenameProfile(params contract.RenameProfileParams) error {
+	return handleRenameProfile(params)
+}
+
+// DeleteProfile delegates to handleDeleteProfile.
+func (s *Service) DeleteProfile(id string) error {
+	return handleDeleteProfile(id)
+}
+
+// ActivateProfile delegates to handleActivateProfile.
+func (s *Service) ActivateProfile(ctx context.Context, params contract.ActivateProfileParams) error {
+	return handleActivateProfile(ctx, params)
+}
+
+// DownloadProfile delegates to handleDownloadProfile.
+func (s *Service) DownloadProfile(ctx context.Context, params contract.DownloadProfileParams) (contract.ProfileDownload, error) {
+	return handleDownloadProfile(ctx, params)
 }
 
 // GetProxies delegates to handleGetProxies.
@@ -82,10 +192,15 @@ func (s *Service) GetProxies() any {
 }
 
 // ChangeProxy delegates to handleChangeProxy.
-func (s *Service) ChangeProxy(params contract.ChangeProxyParams) string {
+func (s *Service) ChangeProxy(params contract.ChangeProxyParams) error {
 	return handleChangeProxy(params)
 }
 
+// GetSelections delegates to handleGetSelections.
+func (s *Service) GetSelections() (map[string]string, error) {
+	return handleGetSelections()
+}
+
 // GetTraffic delegates to handleGetTraffic.
 func (s *Service) GetTraffic(onlyProxy bool) string {
 	return handleGetTraffic(onlyProxy)
@@ -102,8 +217,28 @@ func (s *Service) ResetTraffic() {
 }
 
 // AsyncTestDelay delegates to handleAsyncTestDelay.
-func (s *Service) AsyncTestDelay(payload string) string {
-	return handleAsyncTestDelay(payload)
+func (s *Service) AsyncTestDelay(ctx context.Context, payload string) (string, error) {
+	return handleAsyncTestDelay(ctx, payload)
+}
+
+// TestGroupDelay delegates to handleTestGroupDelay.
+func (s *Service) TestGroupDelay(ctx context.Context, params contract.TestGroupDelayParams) (contract.GroupDelaySummary, error) {
+	return handleTestGroupDelay(ctx, params)
+}
+
+// GetDelayHistory delegates to handleGetDelayHistory.
+func (s *Service) GetDelayHistory(params contract.DelayHistoryParams) (map[string]contract.ProxyDelayHistory, error) {
+	return handleGetDelayHistory(params)
+}
+
+// DiagnoseProxy delegates to handleDiagnoseProxy.
+func (s *Service) DiagnoseProxy(ctx context.Context, params contract.DiagnoseProxyParams) (contract.ProxyDiagnosis, error) {
+	return handleDiagnoseProxy(ctx, params)
+}
+
+// TestThroughput delegates to handleTestThroughput.
+func (s *Service) TestThroughput(ctx context.Context, params contract.TestThroughputParams) (contract.ThroughputResult, error) {
+	return handleTestThroughput(ctx, params)
 }
 
 // GetConnections delegates to handleGetConnections.
@@ -122,37 +257,37 @@ func (s *Service) ResetConnections() bool {
 }
 
 // CloseConnection delegates to handleCloseConnection.
-func (s *Service) CloseConnection(id string) bool {
+func (s *Service) CloseConnection(id string) error {
 	return handleCloseConnection(id)
 }
 
 // GetExternalProviders delegates to handleGetExternalProviders.
-func (s *Service) GetExternalProviders() string {
+func (s *Service) GetExternalProviders() (string, error) {
 	return handleGetExternalProviders()
 }
 
 // GetExternalProvider delegates to handleGetExternalProvider.
-func (s *Service) GetExternalProvider(name string) string {
+func (s *Service) GetExternalProvider(name string) (string, error) {
 	return handleGetExternalProvider(name)
 }
 
 // UpdateGeoData delegates to handleUpdateGeoData.
-func (s *Service) UpdateGeoData(payload string) string {
-	return handleUpdateGeoData(payload)
+func (s *Service) UpdateGeoData(ctx context.Context, payload string) error {
+	return handleUpdateGeoData(ctx, payload)
 }
 
 // SideLoadExternalProvider delegates to handleSideLoadExternalProvider.
-func (s *Service) SideLoadExternalProvider(payload string) string {
+func (s *Service) SideLoadExternalProvider(payload string) error {
 	return handleSideLoadExternalProvider(payload)
 }
 
 // UpdateExternalProvider delegates to handleUpdateExternalProvider.
-func (s *Service) UpdateExternalProvider(providerName string) string {
-	return handleUpdateExternalProvider(providerName)
+func (s *Service) UpdateExternalProvider(ctx context.Context, providerName string) error {
+	return handleUpdateExternalProvider(ctx, providerName)
 }
 
 // GetCountryCode delegates to handleGetCountryCode.
-func (s *Service) GetCountryCode(ip string) string {
+func (s *Service) GetCountryCode(ip string) (string, error) {
 	return handleGetCountryCode(ip)
 }
 
@@ -192,12 +327,12 @@ func (s *Service) StopConnections() {
 }
 
 // StartListener delegates to handleStartListener.
-func (s *Service) StartListener() bool {
+func (s *Service) StartListener() error {
 	return handleStartListener()
 }
 
 // StopListener delegates to handleStopListener.
-func (s *Service) StopListener() bool {
+func (s *Service) StopListener() error {
 	return handleStopListener()
 }
 
@@ -211,12 +346,17 @@ func (s *Service) Suspend(suspended bool) bool {
 	return handleSuspend(suspended)
 }
 
+// NotifyTun delegates to setTunUp.
+func (s *Service) NotifyTun(up bool) {
+	setTunUp(up)
+}
+
 // Crash delegates to handleCrash.
 func (s *Service) Crash() {
 	handleCrash()
 }
 
 // DeleteFile delegates to handleDeleteFile.
-func (s *Service) DeleteFile(path string) string {
+func (s *Service) DeleteFile(path string) error {
 	return handleDeleteFile(path)
 }
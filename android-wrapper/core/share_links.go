@@ -0,0 +1,514 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/adapter/provider"
+	"github.com/metacubex/mihomo/common/convert"
+	"github.com/metacubex/mihomo/common/yaml"
+	"github.com/metacubex/mihomo/tunnel"
+
+	"github.com/dlclark/regexp2"
+)
+
+// handleImportShareLinks converts pasted share links, or a base64 subscription body, into mihomo proxy
+// maps. Each non-empty line is converted on its own, so one bad link only costs its own entry; lines
+// are numbered in the decoded body. Converted proxies are checked with mihomo's proxy parser, and
+// duplicate names get a "-01", "-02", ... suffix.
+func handleImportShareLinks(data string) contract.ShareLinkImport {
+	body := strings.TrimSpace(data)
+	if !strings.Contains(body, "://") {
+		body = string(convert.DecodeBase64([]byte(strings.Join(strings.Fields(body), ""))))
+	}
+
+	result := contract.ShareLinkImport{Proxies: []map[string]any{}, Errors: []contract.ShareLinkError{}}
+	names := make(map[string]int)
+	for i, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		proxy, err := importShareLink(line)
+		if err != nil {
+			result.Errors = append(result.Errors, contract.ShareLinkError{Line: i + 1, Message: err.Error()})
+			continue
+		}
+		proxy["name"] = uniqueProxyName(names, proxy["name"].(string))
+		result.Proxies = append(result.Proxies, proxy)
+	}
+	return result
+}
+
+// importShareLink converts a single share link and checks that mihomo accepts the result.
+func importShareLink(line string) (map[string]any, error) {
+	scheme, _, found := strings.Cut(line, "://")
+	if !found {
+		return nil, fmt.Errorf("not a share link")
+	}
+	proxies, err := convert.ConvertsV2Ray([]byte(line))
+	if err != nil || len(proxies) == 0 {
+		return nil, fmt.Errorf("unsupported or malformed %s link", strings.ToLower(scheme))
+	}
+
+	proxy := proxies[0]
+	if port, err := strconv.Atoi(fmt.Sprint(proxy["port"])); err == nil {
+		proxy["port"] = port
+	}
+	if name, _ := proxy["name"].(string); name == "" {
+		proxy["name"] = net.JoinHostPort(fmt.Sprint(proxy["server"]), fmt.Sprint(proxy["port"]))
+	}
+
+	parsed, err := adapter.ParseProxy(proxy)
+	if err != nil {
+		return nil, err
+	}
+	_ = parsed.Close()
+	return proxy, nil
+}
+
+// uniqueProxyName suffixes repeated names the way mihomo's subscription converter does, skipping
+// suffixed names that are already taken. names holds every name returned so far with the last
+// suffix used for it.
+func uniqueProxyName(names map[string]int, name string) string {
+	if _, ok := names[name]; !ok {
+		names[name] = 0
+		return name
+	}
+	for {
+		names[name]++
+		candidate := fmt.Sprintf("%s-%02d", name, names[name])
+		if _, ok := names[candidate]; !ok {
+			names[candidate] = 0
+			return candidate
+		}
+	}
+}
+
+// handleExportShareLink encodes a proxy of the applied config, or of one of its proxy providers, as a
+// share link. Groups and built-ins have no entry to encode. Settings a share link cannot carry (dialer
+// options, smux, ...) are dropped.
+func handleExportShareLink(name string) (string, error) {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	if name == "" {
+		return "", missingParam("name")
+	}
+	if err := requireState(configuredStates...); err != nil {
+		return "", err
+	}
+
+	proxy, err := configProxy(name)
+	if err != nil {
+		return "", err
+	}
+	if proxy != nil {
+		return encodeShareLink(proxy)
+	}
+
+	if existing, ok := allProxies()[name]; ok {
+		if providerName := existing.ProxyInfo().ProviderName; providerName != "" {
+			if proxy, err = providerProxy(name, providerName); err != nil {
+				return "", err
+			}
+			if proxy != nil {
+				return encodeShareLink(proxy)
+			}
+		}
+		return "", contract.Errorf(contract.ErrUnsupported, "proxy %q has no proxy entry to export", name)
+	}
+	return "", contract.Errorf(contract.ErrNotFound, "proxy %q not found", name)
+}
+
+// configProxy returns the raw entry called name in the applied config's proxies section, or nil.
+// Provider proxies have no entry there. The caller holds coreMu.
+func configProxy(name string) (map[string]any, error) {
+	data, err := currentConfigData()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := rawConfigMap(data)
+	if err != nil {
+		return nil, err
+	}
+	proxies, _ := cfg["proxies"].([]any)
+	for _, entry := range proxies {
+		if proxy, ok := entry.(map[string]any); ok && proxy["name"] == name {
+			return proxy, nil
+		}
+	}
+	return nil, nil
+}
+
+// providerProxy returns the raw entry called name among the proxies of a proxy provider, or nil. Like
+// mihomo's provider parser it reads the provider's cached file, falling back to its payload, and
+// applies the provider's dialer-proxy and override before matching the name. The caller holds coreMu.
+func providerProxy(name, providerName string) (map[string]any, error) {
+	data, err := currentConfigData()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := rawConfigMap(data)
+	if err != nil {
+		return nil, err
+	}
+	providers, _ := cfg["proxy-providers"].(map[string]any)
+	settings, _ := providers[providerName].(map[string]any)
+	if settings == nil {
+		return nil, nil
+	}
+
+	entries, _ := settings["payload"].([]any)
+	if pv, ok := tunnel.Providers()[providerName].(*provider.ProxySetProvider); ok {
+		if buf, err := os.ReadFile(pv.Vehicle().Path()); err == nil {
+			if proxies, err := providerFileProxies(buf); err == nil {
+				entries = proxies
+			}
+		}
+	}
+
+	override, _ := settings["override"].(map[string]any)
+	for _, entry := range entries {
+		proxy, ok := entry.(map[string]any)
+		if !ok {
+			continue
+		}
+		entryName, ok := proxy["name"].(string)
+		if !ok {
+			continue
+		}
+		if dialerProxy := stringValue(settings, "dialer-proxy"); dialerProxy != "" {
+			proxy["dialer-proxy"] = dialerProxy
+		}
+		for key, value := range override {
+			switch key {
+			case "additional-prefix", "additional-suffix", "proxy-name":
+			default:
+				proxy[key] = value
+			}
+		}
+		if entryName, err = overrideProxyName(entryName, override); err != nil {
+			return nil, contract.WrapError(contract.ErrConfigParse, err)
+		}
+		if entryName == name {
+			proxy["name"] = entryName
+			return proxy, nil
+		}
+	}
+	return nil, nil
+}
+
+// providerFileProxies decodes the proxies of a provider file: a YAML document with a proxies list, or
+// share links as mihomo's converter reads them.
+func providerFileProxies(buf []byte) ([]any, error) {
+	var schema struct {
+		Proxies []map[string]any `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal(buf, &schema); err != nil {
+		if schema.Proxies, err = convert.ConvertsV2Ray(buf); err != nil {
+			return nil, err
+		}
+	}
+	// Round-trip through JSON for the same value types as the config entries.
+	encoded, err := json.Marshal(schema.Proxies)
+	if err != nil {
+		return nil, err
+	}
+	var proxies []any
+	if err := json.Unmarshal(encoded, &proxies); err != nil {
+		return nil, err
+	}
+	return proxies, nil
+}
+
+// overrideProxyName renames a provider proxy the way the provider override does: additional-prefix,
+// then additional-suffix, then each proxy-name replacement in order.
+func overrideProxyName(name string, override map[string]any) (string, error) {
+	if prefix, ok := override["additional-prefix"].(string); ok {
+		name = prefix + name
+	}
+	if suffix, ok := override["additional-suffix"].(string); ok {
+		name += suffix
+	}
+	replacements, _ := override["proxy-name"].([]any)
+	for _, entry := range replacements {
+		replacement, _ := entry.(map[string]any)
+		pattern, err := regexp2.Compile(stringValue(replacement, "pattern"), regexp2.None)
+		if err != nil {
+			return "", err
+		}
+		if name, err = pattern.Replace(name, stringValue(replacement, "target"), 0, -1); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
+
+// encodeShareLink encodes a raw proxy config in the link format mihomo's converter reads back.
+func encodeShareLink(proxy map[string]any) (string, error) {
+	name := stringValue(proxy, "name")
+	host := net.JoinHostPort(stringValue(proxy, "server"), stringValue(proxy, "port"))
+	query := url.Values{}
+	link := url.URL{Host: host, Fragment: name}
+
+	switch proxyType := stringValue(proxy, "type"); proxyType {
+	case "ss":
+		userInfo := stringValue(proxy, "cipher") + ":" + stringValue(proxy, "password")
+		link.Scheme = "ss"
+		link.User = url.User(base64.RawURLEncoding.EncodeToString([]byte(userInfo)))
+		opts := objectValue(proxy, "plugin-opts")
+		switch plugin := stringValue(proxy, "plugin"); plugin {
+		case "":
+		case "obfs":
+			query.Set("plugin", "obfs-local;obfs="+stringValue(opts, "mode")+";obfs-host="+stringValue(opts, "host"))
+		case "v2ray-plugin":
+			value := "v2ray-plugin;mode=" + stringValue(opts, "mode") + ";host=" + stringValue(opts, "host") + ";path=" + stringValue(opts, "path")
+			if boolValue(opts, "tls") {
+				value += ";tls"
+			}
+			query.Set("plugin", value)
+		default:
+			return "", contract.Errorf(contract.ErrUnsupported, "ss plugin %q has no share link form", plugin)
+		}
+		if boolValue(proxy, "udp-over-tcp") {
+			query.Set("uot", "1")
+		}
+
+	case "vmess":
+		return encodeVmessLink(proxy)
+
+	case "vless":
+		link.Scheme = "vless"
+		link.User = url.User(stringValue(proxy, "uuid"))
+		setTransport(proxy, query)
+		setNonEmpty(query, "sni", stringValue(proxy, "servername"))
+		switch reality := objectValue(proxy, "reality-opts"); {
+		case len(reality) > 0:
+			query.Set("security", "reality")
+			query.Set("pbk", stringValue(reality, "public-key"))
+			setNonEmpty(query, "sid", stringValue(reality, "short-id"))
+		case boolValue(proxy, "tls"):
+			query.Set("security", "tls")
+		default:
+			query.Set("security", "none")
+		}
+		if query.Get("security") != "none" {
+			setNonEmpty(query, "fp", stringValue(proxy, "client-fingerprint"))
+			setNonEmpty(query, "alpn", listValue(proxy, "alpn"))
+		}
+		setNonEmpty(query, "flow", stringValue(proxy, "flow"))
+		encryption := stringValue(proxy, "encryption")
+		if encryption == "" {
+			encryption = "none"
+		}
+		query.Set("encryption", encryption)
+		if boolValue(proxy, "packet-addr") {
+			query.Set("packetEncoding", "packet")
+		} else if xudp, ok := proxy["xudp"].(bool); ok && !xudp {
+			query.Set("packetEncoding", "none")
+		}
+
+	case "trojan":
+		link.Scheme = "trojan"
+		link.User = url.User(stringValue(proxy, "password"))
+		setTransport(proxy, query)
+		setNonEmpty(query, "sni", stringValue(proxy, "sni"))
+		setNonEmpty(query, "alpn", listValue(proxy, "alpn"))
+		setNonEmpty(query, "fp", stringValue(proxy, "client-fingerprint"))
+		if boolValue(proxy, "skip-cert-verify") {
+			query.Set("allowInsecure", "1")
+		}
+
+	case "hysteria2":
+		link.Scheme = "hysteria2"
+		if password := stringValue(proxy, "password"); password != "" {
+			link.User = url.User(password)
+		}
+		setNonEmpty(query, "sni", stringValue(proxy, "sni"))
+		setNonEmpty(query, "obfs", stringValue(proxy, "obfs"))
+		setNonEmpty(query, "obfs-password", stringValue(proxy, "obfs-password"))
+		setNonEmpty(query, "alpn", listValue(proxy, "alpn"))
+		setNonEmpty(query, "pinSHA256", stringValue(proxy, "fingerprint"))
+		setNonEmpty(query, "up", stringValue(proxy, "up"))
+		setNonEmpty(query, "down", stringValue(proxy, "down"))
+		if boolValue(proxy, "skip-cert-verify") {
+			query.Set("insecure", "1")
+		}
+
+	case "tuic":
+		link.Scheme = "tuic"
+		if token := stringValue(proxy, "token"); token != "" {
+			link.User = url.User(token)
+		} else {
+			link.User = url.UserPassword(stringValue(proxy, "uuid"), stringValue(proxy, "password"))
+		}
+		setNonEmpty(query, "congestion_control", stringValue(proxy, "congestion-controller"))
+		setNonEmpty(query, "udp_relay_mode", stringValue(proxy, "udp-relay-mode"))
+		setNonEmpty(query, "sni", stringValue(proxy, "sni"))
+		setNonEmpty(query, "alpn", listValue(proxy, "alpn"))
+		if boolValue(proxy, "disable-sni") {
+			query.Set("disable_sni", "1")
+		}
+
+	case "socks5", "http":
+		link.Scheme = proxyType
+		if proxyType == "http" && boolValue(proxy, "tls") {
+			link.Scheme = "https"
+		}
+		if username := stringValue(proxy, "username"); username != "" {
+			link.User = url.UserPassword(username, stringValue(proxy, "password"))
+		}
+
+	default:
+		return "", contract.Errorf(contract.ErrUnsupported, "%s proxies have no share link form", proxyType)
+	}
+
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
+
+// encodeVmessLink encodes a vmess proxy as a V2RayN link: base64 JSON after the scheme.
+func encodeVmessLink(proxy map[string]any) (string, error) {
+	values := map[string]string{
+		"v":    "2",
+		"ps":   stringValue(proxy, "name"),
+		"add":  stringValue(proxy, "server"),
+		"port": stringValue(proxy, "port"),
+		"id":   stringValue(proxy, "uuid"),
+		"aid":  stringValue(proxy, "alterId"),
+		"scy":  stringValue(proxy, "cipher"),
+		"net":  "tcp",
+		"type": "none",
+		"sni":  stringValue(proxy, "servername"),
+	}
+	if values["aid"] == "" {
+		values["aid"] = "0"
+	}
+	if boolValue(proxy, "tls") {
+		values["tls"] = "tls"
+		if alpn := listValue(proxy, "alpn"); alpn != "" {
+			values["alpn"] = alpn
+		}
+	}
+
+	switch network := stringValue(proxy, "network"); network {
+	case "", "tcp":
+	case "ws", "httpupgrade":
+		opts := objectValue(proxy, "ws-opts")
+		values["net"] = network
+		values["path"] = stringValue(opts, "path")
+		values["host"] = stringValue(objectValue(opts, "headers"), "Host")
+	case "grpc":
+		values["net"] = "grpc"
+		values["path"] = stringValue(objectValue(proxy, "grpc-opts"), "grpc-service-name")
+	case "http":
+		opts := objectValue(proxy, "http-opts")
+		values["type"] = "http"
+		values["path"] = firstValue(opts, "path")
+		values["host"] = firstValue(objectValue(opts, "headers"), "Host")
+	case "h2":
+		opts := objectValue(proxy, "h2-opts")
+		values["net"] = "h2"
+		values["path"] = stringValue(opts, "path")
+		values["host"] = firstValue(opts, "host")
+	default:
+		return "", contract.Errorf(contract.ErrUnsupported, "vmess network %q has no share link form", network)
+	}
+
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return "", contract.WrapError(contract.ErrInternal, err)
+	}
+	return "vmess://" + base64.StdEncoding.EncodeToString(encoded), nil
+}
+
+// setTransport sets the Xray share link transport parameters (type, path, host, ...) of a vless or trojan proxy.
+func setTransport(proxy map[string]any, query url.Values) {
+	switch network := stringValue(proxy, "network"); network {
+	case "", "tcp":
+		query.Set("type", "tcp")
+		query.Set("headerType", "none")
+	case "ws", "httpupgrade":
+		opts := objectValue(proxy, "ws-opts")
+		query.Set("type", network)
+		setNonEmpty(query, "path", stringValue(opts, "path"))
+		setNonEmpty(query, "host", stringValue(objectValue(opts, "headers"), "Host"))
+	case "grpc":
+		query.Set("type", "grpc")
+		setNonEmpty(query, "serviceName", stringValue(objectValue(proxy, "grpc-opts"), "grpc-service-name"))
+	case "http":
+		opts := objectValue(proxy, "http-opts")
+		query.Set("type", "tcp")
+		query.Set("headerType", "http")
+		setNonEmpty(query, "path", firstValue(opts, "path"))
+		setNonEmpty(query, "host", firstValue(objectValue(opts, "headers"), "Host"))
+	case "h2":
+		opts := objectValue(proxy, "h2-opts")
+		query.Set("type", "http")
+		setNonEmpty(query, "path", stringValue(opts, "path"))
+		setNonEmpty(query, "host", firstValue(opts, "host"))
+	default:
+		query.Set("type", network)
+	}
+}
+
+func setNonEmpty(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
+
+// stringValue returns a generic JSON value as link text; whole numbers are printed without a fraction.
+func stringValue(m map[string]any, key string) string {
+	switch v := m[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func boolValue(m map[string]any, key string) bool {
+	b, _ := m[key].(bool)
+	return b
+}
+
+func objectValue(m map[string]any, key string) map[string]any {
+	object, _ := m[key].(map[string]any)
+	return object
+}
+
+// listValue joins a list value with commas, the form share links use for ALPN lists.
+func listValue(m map[string]any, key string) string {
+	items, _ := m[key].([]any)
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		values = append(values, fmt.Sprint(item))
+	}
+	return strings.Join(values, ",")
+}
+
+// firstValue returns the first element of a list value, or the value itself if it is not a list.
+func firstValue(m map[string]any, key string) string {
+	if items, ok := m[key].([]any); ok {
+		if len(items) == 0 {
+			return ""
+		}
+		return fmt.Sprint(items[0])
+	}
+	return stringValue(m, key)
+}
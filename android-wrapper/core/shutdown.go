@@ -0,0 +1,120 @@
+package core
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/hub/executor"
+	"github.com/metacubex/mihomo/log"
+	"github.com/metacubex/mihomo/tunnel/statistic"
+)
+
+const (
+	// shutdownTaskTimeout bounds how long shutdown waits for cancelled tasks to return.
+	shutdownTaskTimeout = 5 * time.Second
+	// drainPollInterval is how often shutdown checks whether connections have drained.
+	drainPollInterval = 100 * time.Millisecond
+)
+
+// handleShutdown stops the core so it can be initialized again in the same process:
+// it cancels running tasks, stops TUN and inbound listeners, drains open connections for up to
+// params.GracePeriodMs (or until ctx is done), stops every stream and shuts down the mihomo executor.
+func handleShutdown(ctx context.Context, params contract.ShutdownParams) contract.ShutdownSummary {
+	coreMu.Lock()
+	defer coreMu.Unlock()
+
+	started := time.Now()
+	summary := contract.ShutdownSummary{StreamsStopped: []string{}}
+
+	recordSelections()
+	if requireState(configuredStates...) == nil {
+		collectDelayHistory()
+	}
+	flushDelayHistory()
+	stopPeriodicSync()
+	enterState(contract.StateShuttingDown, "shutdown")
+	stopProfileRefreshes()
+	running := closeTasks()
+	summary.TasksCancelled = len(running)
+
+	if tunController != nil {
+		tunController.StopTun()
+	}
+	setTunUp(false)
+	stopInboundListeners()
+
+	grace := time.Duration(params.GracePeriodMs) * time.Millisecond
+	summary.ConnectionsDrained, summary.ConnectionsClosed = drainConnections(ctx, grace)
+	summary.TasksAbandoned = waitTasks(running, shutdownTaskTimeout)
+
+	if handleStopLog() {
+		summary.StreamsStopped = append(summary.StreamsStopped, string(contract.LogMessage))
+	}
+	if handleStopMemory() {
+		summary.StreamsStopped = append(summary.StreamsStopped, string(contract.MemoryMessage))
+	}
+	if handleStopConnections() {
+		summary.StreamsStopped = append(summary.StreamsStopped, string(contract.ConnectionsMessage))
+	}
+
+	executor.Shutdown()
+	resetConfigSnapshots()
+	reopenTasks()
+	enterState(contract.StateUninitialized, "shutdown")
+
+	summary.GoroutinesRemaining = runtime.NumGoroutine()
+	summary.DurationMs = time.Since(started).Milliseconds()
+	log.Infoln("[APP] shutdown: %d connections drained, %d closed, %d tasks cancelled, %d abandoned",
+		summary.ConnectionsDrained, summary.ConnectionsClosed, summary.TasksCancelled, summary.TasksAbandoned)
+	return summary
+}
+
+// drainConnections waits up to grace (or until ctx is done) for open connections to finish,
+// then closes the rest. It returns how many finished on their own and how many were closed.
+func drainConnections(ctx context.Context, grace time.Duration) (drained, closed int) {
+	initial := countConnections()
+	if initial > 0 && grace > 0 {
+		timer := time.NewTimer(grace)
+		ticker := time.NewTicker(drainPollInterval)
+	wait:
+		for countConnections() > 0 {
+			select {
+			case <-ticker.C:
+			case <-timer.C:
+				break wait
+			case <-ctx.Done():
+				break wait
+			}
+		}
+		ticker.Stop()
+		timer.Stop()
+	}
+
+	var trackers []statistic.Tracker
+	statistic.DefaultManager.Range(func(c statistic.Tracker) bool {
+		trackers = append(trackers, c)
+		return true
+	})
+	for _, t := range trackers {
+		_ = t.Close()
+	}
+
+	closed = len(trackers)
+	if drained = initial - closed; drained < 0 {
+		drained = 0
+	}
+	return drained, closed
+}
+
+// countConnections returns the number of tracked connections.
+func countConnections() int {
+	n := 0
+	statistic.DefaultManager.Range(func(statistic.Tracker) bool {
+		n++
+		return true
+	})
+	return n
+}
@@ -1,3 +0,0 @@
-//go:build !android || !cgo
-
-package core
@@ -0,0 +1,51 @@
+package core
+
+import "time"
+
+// syncInterval is how often state changed outside the core's methods is picked up: selector choices
+// made through the external controller and delay results of mihomo's health checks.
+const syncInterval = 5 * time.Second
+
+// syncStop stops the sync goroutine started by initClash; guarded by coreMu.
+var syncStop chan struct{}
+
+// startPeriodicSync starts syncing periodically until stopPeriodicSync.
+func startPeriodicSync() {
+	stopPeriodicSync()
+	stop := make(chan struct{})
+	syncStop = stop
+	go periodicSync(stop)
+}
+
+// stopPeriodicSync stops the sync goroutine without waiting for it, since it may be waiting for coreMu.
+func stopPeriodicSync() {
+	if syncStop != nil {
+		close(syncStop)
+		syncStop = nil
+	}
+}
+
+func periodicSync(stop <-chan struct{}) {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		coreMu.Lock()
+		select {
+		case <-stop:
+		default:
+			if requireState(configuredStates...) == nil {
+				recordSelections()
+				collectDelayHistory()
+			}
+			flushDelayHistory()
+		}
+		coreMu.Unlock()
+	}
+}
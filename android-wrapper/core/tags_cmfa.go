@@ -0,0 +1,7 @@
+//go:build cmfa
+
+package core
+
+func init() {
+	buildTags = append(buildTags, "cmfa")
+}
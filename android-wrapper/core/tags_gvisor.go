@@ -0,0 +1,7 @@
+//go:build with_gvisor
+
+package core
+
+func init() {
+	buildTags = append(buildTags, "with_gvisor")
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"mihomo_android_wrapper/contract"
+)
+
+// tasks tracks cancellable work (delay tests, provider and Geo updates) so shutdown can cancel it and wait.
+var (
+	tasksMu     sync.Mutex
+	tasks       = make(map[*task]struct{})
+	tasksClosed bool
+)
+
+type task struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// beginTask registers work running under ctx if the core is in one of allowed states.
+// The returned context is cancelled by shutdown; the returned func must be called when the work ends.
+func beginTask(ctx context.Context, allowed ...contract.State) (context.Context, func(), error) {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+
+	if tasksClosed {
+		return nil, nil, contract.Errorf(contract.ErrInvalidState, "not allowed in state %s", contract.StateShuttingDown)
+	}
+	if err := requireState(allowed...); err != nil {
+		return nil, nil, err
+	}
+
+	taskCtx, cancel := context.WithCancel(ctx)
+	t := &task{cancel: cancel, done: make(chan struct{})}
+	tasks[t] = struct{}{}
+
+	return taskCtx, func() {
+		tasksMu.Lock()
+		delete(tasks, t)
+		tasksMu.Unlock()
+		cancel()
+		close(t.done)
+	}, nil
+}
+
+// closeTasks rejects new tasks and cancels running ones; it returns the cancelled tasks.
+func closeTasks() []*task {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+
+	tasksClosed = true
+	running := make([]*task, 0, len(tasks))
+	for t := range tasks {
+		t.cancel()
+		running = append(running, t)
+	}
+	return running
+}
+
+// waitTasks waits up to timeout for cancelled tasks to return; it returns how many are still running.
+func waitTasks(running []*task, timeout time.Duration) int {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for i, t := range running {
+		select {
+		case <-t.done:
+		case <-deadline.C:
+			remaining := 0
+			for _, t := range running[i:] {
+				select {
+				case <-t.done:
+				default:
+					remaining++
+				}
+			}
+			return remaining
+		}
+	}
+	return 0
+}
+
+// reopenTasks accepts new tasks again once shutdown has finished.
+func reopenTasks() {
+	tasksMu.Lock()
+	tasksClosed = false
+	tasksMu.Unlock()
+}
@@ -0,0 +1,285 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"mihomo_android_wrapper/contract"
+
+	"github.com/metacubex/mihomo/constant"
+
+	"github.com/metacubex/http"
+)
+
+const (
+	// defaultThroughputDuration bounds each direction in milliseconds when no duration is given.
+	defaultThroughputDuration = 10000
+	// defaultThroughputMaxBytes bounds each direction when no max-bytes are given.
+	defaultThroughputMaxBytes = 100 << 20
+	// throughputProgressInterval is how often a ThroughputMessage is emitted while data moves.
+	throughputProgressInterval = 500 * time.Millisecond
+	// throughputBufferSize is the size of the reads and writes of a measurement.
+	throughputBufferSize = 32 << 10
+)
+
+// throughputLimits bound one direction of a measurement.
+type throughputLimits struct {
+	duration time.Duration
+	maxBytes int64
+}
+
+// handleTestThroughput downloads params.DownloadURL through the named proxy and, with an UploadURL,
+// then uploads generated data to it. Each direction runs on its own connection until its body ends, its
+// duration runs out or max-bytes moved, and progress is emitted as ThroughputMessage. A failed
+// direction is reported in its stats. Cancelling ctx (or shutdown) stops the measurement and reports
+// the context error.
+func handleTestThroughput(ctx context.Context, params contract.TestThroughputParams) (contract.ThroughputResult, error) {
+	if err := requireState(configuredStates...); err != nil {
+		return contract.ThroughputResult{}, err
+	}
+
+	download, err := targetMetadata(params.DownloadURL, "download-url")
+	if err != nil {
+		return contract.ThroughputResult{}, err
+	}
+	var upload constant.Metadata
+	if params.UploadURL != "" {
+		if upload, err = targetMetadata(params.UploadURL, "upload-url"); err != nil {
+			return contract.ThroughputResult{}, err
+		}
+	}
+	limits := throughputLimits{
+		duration: time.Duration(params.Duration) * time.Millisecond,
+		maxBytes: params.MaxBytes,
+	}
+	if limits.duration <= 0 {
+		limits.duration = defaultThroughputDuration * time.Millisecond
+	}
+	if limits.maxBytes <= 0 {
+		limits.maxBytes = defaultThroughputMaxBytes
+	}
+
+	coreMu.Lock()
+	proxy := allProxies()[params.ProxyName]
+	coreMu.Unlock()
+
+	if proxy == nil {
+		return contract.ThroughputResult{}, contract.NewError(contract.ErrNotFound, "proxy not found").
+			WithDetails(map[string]string{"proxy-name": params.ProxyName})
+	}
+
+	ctx, done, err := beginTask(ctx, configuredStates...)
+	if err != nil {
+		return contract.ThroughputResult{}, err
+	}
+	defer done()
+
+	result := contract.ThroughputResult{Name: proxy.Name()}
+	result.Download = measureDownload(ctx, proxy, params.DownloadURL, download, limits)
+	if params.UploadURL != "" && ctx.Err() == nil {
+		stats := measureUpload(ctx, proxy, params.UploadURL, upload, limits)
+		result.Upload = &stats
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return contract.ThroughputResult{}, ctxErr
+	}
+	return result, nil
+}
+
+// throughputRun counts the bytes of one direction and emits its progress.
+type throughputRun struct {
+	name      string
+	direction string
+	url       string
+	started   time.Time
+	bytes     atomic.Int64
+	// reported is when progress was last emitted; only the goroutine moving the data uses it.
+	reported time.Time
+}
+
+// start begins the transfer time.
+func (r *throughputRun) start() {
+	r.started = time.Now()
+	r.reported = r.started
+}
+
+// add counts n bytes and emits progress once per throughputProgressInterval.
+func (r *throughputRun) add(n int) {
+	bytes := r.bytes.Add(int64(n))
+	if time.Since(r.reported) < throughputProgressInterval {
+		return
+	}
+	r.reported = time.Now()
+	elapsed := r.reported.Sub(r.started)
+	emitMessage(contract.Message{
+		Type: contract.ThroughputMessage,
+		Data: contract.ThroughputProgress{
+			Name:           r.name,
+			Direction:      r.direction,
+			Bytes:          bytes,
+			ElapsedMs:      elapsed.Milliseconds(),
+			BytesPerSecond: bytesPerSecond(bytes, elapsed),
+		},
+	})
+}
+
+// stats returns the outcome of the run; err is set when it stopped with ThroughputStopError.
+func (r *throughputRun) stats(stopped string, err error) contract.ThroughputStats {
+	stats := contract.ThroughputStats{URL: r.url, Bytes: r.bytes.Load(), Stopped: stopped}
+	if !r.started.IsZero() {
+		elapsed := time.Since(r.started)
+		stats.DurationMs = elapsed.Milliseconds()
+		stats.BytesPerSecond = bytesPerSecond(stats.Bytes, elapsed)
+	}
+	if err != nil {
+		stats.Error = err.Error()
+	}
+	return stats
+}
+
+// stopReason tells a transfer cut short by the direction's duration from a failed one.
+func (r *throughputRun) stopReason(ctx context.Context, err error) (string, error) {
+	if !r.started.IsZero() && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return contract.ThroughputStopDuration, nil
+	}
+	return contract.ThroughputStopError, err
+}
+
+func bytesPerSecond(bytes int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(bytes) / elapsed.Seconds())
+}
+
+// measureDownload reads rawURL through proxy within limits.
+func measureDownload(ctx context.Context, proxy constant.Proxy, rawURL string, metadata constant.Metadata, limits throughputLimits) contract.ThroughputStats {
+	ctx, cancel := context.WithTimeout(ctx, limits.duration)
+	defer cancel()
+	run := &throughputRun{name: proxy.Name(), direction: contract.ThroughputDownload, url: rawURL}
+
+	client, closeClient, err := newThroughputClient(ctx, proxy, metadata)
+	if err != nil {
+		return run.stats(contract.ThroughputStopError, err)
+	}
+	defer closeClient()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return run.stats(contract.ThroughputStopError, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return run.stats(contract.ThroughputStopError, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return run.stats(contract.ThroughputStopError, fmt.Errorf("unexpected status %d", resp.StatusCode))
+	}
+
+	run.start()
+	buf := make([]byte, throughputBufferSize)
+	for {
+		if remaining := limits.maxBytes - run.bytes.Load(); remaining < int64(len(buf)) {
+			buf = buf[:remaining]
+		}
+		n, err := resp.Body.Read(buf)
+		run.add(n)
+		switch {
+		case run.bytes.Load() >= limits.maxBytes:
+			return run.stats(contract.ThroughputStopMaxBytes, nil)
+		case errors.Is(err, io.EOF):
+			return run.stats(contract.ThroughputStopEnd, nil)
+		case err != nil:
+			return run.stats(run.stopReason(ctx, err))
+		}
+	}
+}
+
+// measureUpload posts generated data to rawURL through proxy within limits.
+func measureUpload(ctx context.Context, proxy constant.Proxy, rawURL string, metadata constant.Metadata, limits throughputLimits) contract.ThroughputStats {
+	ctx, cancel := context.WithTimeout(ctx, limits.duration)
+	defer cancel()
+	run := &throughputRun{name: proxy.Name(), direction: contract.ThroughputUpload, url: rawURL}
+
+	client, closeClient, err := newThroughputClient(ctx, proxy, metadata)
+	if err != nil {
+		return run.stats(contract.ThroughputStopError, err)
+	}
+	defer closeClient()
+
+	body := &throughputBody{ctx: ctx, run: run, remaining: limits.maxBytes}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, body)
+	if err != nil {
+		return run.stats(contract.ThroughputStopError, err)
+	}
+	req.ContentLength = limits.maxBytes
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	run.start()
+	resp, err := client.Do(req)
+	if err != nil {
+		return run.stats(run.stopReason(ctx, err))
+	}
+	drainResponse(resp)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return run.stats(contract.ThroughputStopError, fmt.Errorf("unexpected status %d", resp.StatusCode))
+	}
+	if run.bytes.Load() >= limits.maxBytes {
+		return run.stats(contract.ThroughputStopMaxBytes, nil)
+	}
+	return run.stats(contract.ThroughputStopEnd, nil)
+}
+
+// newThroughputClient dials the target of metadata through proxy and returns a client using that
+// connection, with the function that releases both.
+func newThroughputClient(ctx context.Context, proxy constant.Proxy, metadata constant.Metadata) (*http.Client, func(), error) {
+	conn, err := proxy.DialContext(ctx, &metadata)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := newDelayClient(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	return client, func() {
+		client.CloseIdleConnections()
+		_ = conn.Close()
+	}, nil
+}
+
+// throughputBody is the generated upload body; it counts what the transport reads and stops when
+// the direction ends.
+type throughputBody struct {
+	ctx       context.Context
+	run       *throughputRun
+	remaining int64
+}
+
+func (b *throughputBody) Read(p []byte) (int, error) {
+	if err := b.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if b.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	if len(p) > throughputBufferSize {
+		p = p[:throughputBufferSize]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	b.remaining -= int64(len(p))
+	b.run.add(len(p))
+	return len(p), nil
+}
@@ -1,16 +1,15 @@
-//go:build android && cgo
-
 package core
 
 import (
 	"encoding/json"
+	"sort"
+
+	"mihomo_android_wrapper/contract"
 
 	"github.com/metacubex/mihomo/adapter"
 	"github.com/metacubex/mihomo/component/dialer"
 	"github.com/metacubex/mihomo/component/process"
 	"github.com/metacubex/mihomo/component/resolver"
-	"github.com/metacubex/mihomo/hub"
-	"github.com/metacubex/mihomo/hub/executor"
 	"github.com/metacubex/mihomo/log"
 	"github.com/metacubex/mihomo/tunnel"
 )
@@ -27,71 +26,115 @@ type UpdateParams struct {
 	ExternalController *string                  `json:"external-controller"`
 	Interface          *string                  `json:"interface-name"`
 	UnifiedDelay       *bool                    `json:"unified-delay"`
+	// MergePatch is an RFC 7396 JSON merge patch applied to the raw config.
+	MergePatch map[string]any `json:"merge-patch"`
+	// JSONPatch is an RFC 6902 JSON patch applied to the raw config, after MergePatch.
+	JSONPatch []PatchOperation `json:"json-patch"`
 }
 
 // handleUpdateConfig incrementally updates the loaded config without restarting the core.
-func handleUpdateConfig(data []byte) string {
+// MergePatch and JSONPatch edit the raw applied config and persist in it; the other fields
+// override the parsed config for this apply only. A config file is re-read, so edits made since
+// setupConfig are picked up, with every earlier patch replayed on top. It returns the top-level
+// sections that changed.
+func handleUpdateConfig(data []byte) (contract.ConfigUpdate, error) {
 	coreMu.Lock()
 	defer coreMu.Unlock()
 
-	if !isInit {
-		return "not initialized"
+	if err := requireState(configuredStates...); err != nil {
+		return contract.ConfigUpdate{}, err
 	}
 
 	var params UpdateParams
 	if err := json.Unmarshal(data, &params); err != nil {
-		return err.Error()
+		return contract.ConfigUpdate{}, invalidParams(err)
 	}
 
-	cfg, err := executor.Parse()
+	current, err := currentConfigData()
+	if err != nil {
+		return contract.ConfigUpdate{}, err
+	}
+	var patch *configPatch
+	if params.MergePatch != nil || len(params.JSONPatch) > 0 {
+		patch = &configPatch{merge: params.MergePatch, ops: params.JSONPatch}
+	}
+	patched, patches, err := replayConfig(patch)
 	if err != nil {
-		return err.Error()
+		return contract.ConfigUpdate{}, err
 	}
 
-	// Android provides the VPN fd via startTUN(), so we disable mihomo's built-in TUN.
-	// This avoids attempting to open /dev/net/tun (which usually requires root).
-	if cfg.General != nil {
-		cfg.General.Tun.Enable = false
+	cfg, err := parseConfigBytes(patched)
+	if err != nil {
+		return contract.ConfigUpdate{}, err
+	}
+	changed, err := changedSections(current, patched)
+	if err != nil {
+		return contract.ConfigUpdate{}, err
 	}
 
+	// note records a field override as a changed section when it differs from the parsed value.
+	note := func(section string, differs bool) {
+		if !differs {
+			return
+		}
+		for _, name := range changed {
+			if name == section {
+				return
+			}
+		}
+		changed = append(changed, section)
+	}
 	if cfg.General != nil {
 		if params.AllowLan != nil {
+			note("allow-lan", cfg.General.AllowLan != *params.AllowLan)
 			cfg.General.AllowLan = *params.AllowLan
 		}
 		if params.MixedPort != nil {
+			note("mixed-port", cfg.General.MixedPort != *params.MixedPort)
 			cfg.General.MixedPort = *params.MixedPort
 		}
 		if params.Sniffing != nil {
+			note("sniffer", cfg.General.Sniffing != *params.Sniffing)
 			cfg.General.Sniffing = *params.Sniffing
 		}
 		if params.FindProcessMode != nil {
+			note("find-process-mode", cfg.General.FindProcessMode != *params.FindProcessMode)
 			cfg.General.FindProcessMode = *params.FindProcessMode
 		}
 		if params.TCPConcurrent != nil {
+			note("tcp-concurrent", cfg.General.TCPConcurrent != *params.TCPConcurrent)
 			cfg.General.TCPConcurrent = *params.TCPConcurrent
 		}
 		if params.Interface != nil {
+			note("interface-name", cfg.General.Interface != *params.Interface)
 			cfg.General.Interface = *params.Interface
 		}
 		if params.UnifiedDelay != nil {
+			note("unified-delay", cfg.General.UnifiedDelay != *params.UnifiedDelay)
 			cfg.General.UnifiedDelay = *params.UnifiedDelay
 		}
 		if params.Mode != nil {
+			note("mode", cfg.General.Mode != *params.Mode)
 			cfg.General.Mode = *params.Mode
 		}
 		if params.LogLevel != nil {
+			note("log-level", cfg.General.LogLevel != *params.LogLevel)
 			cfg.General.LogLevel = *params.LogLevel
 		}
 		if params.IPv6 != nil {
+			note("ipv6", cfg.General.IPv6 != *params.IPv6)
 			cfg.General.IPv6 = *params.IPv6
 		}
 	}
 
 	if cfg.Controller != nil && params.ExternalController != nil {
+		note("external-controller", cfg.Controller.ExternalController != *params.ExternalController)
 		cfg.Controller.ExternalController = *params.ExternalController
 	}
+	sort.Strings(changed)
 
-	hub.ApplyConfig(cfg)
+	// Re-applying replaces every proxy group, so keep the host's current selections.
+	applyConfig(cfg, currentSelections())
 
 	// Keep behavior consistent with the old implementation: sync global state for hosts relying on side effects.
 	if cfg.General != nil {
@@ -105,5 +148,64 @@ func handleUpdateConfig(data []byte) string {
 		resolver.DisableIPv6 = !cfg.General.IPv6
 	}
 
-	return ""
+	if appliedConfig != nil {
+		appliedConfig = &configSnapshot{data: patched, source: appliedConfig.source, selected: appliedConfig.selected, patches: patches}
+	}
+	enterConfiguredState(contract.StateRunning, "updateConfig")
+	return contract.ConfigUpdate{Changed: changed}, nil
+}
+
+// replayConfig rebuilds the applied config with patch, if any, added to its updateConfig patches. A
+// payload already contains its patches and only gets patch; a config file is re-read and gets all
+// of them again. It returns the content and the patches in it.
+func replayConfig(patch *configPatch) ([]byte, []configPatch, error) {
+	data, err := currentConfigData()
+	if err != nil {
+		return nil, nil, err
+	}
+	var patches []configPatch
+	if appliedConfig != nil {
+		// Copy, so the recorded snapshot keeps its own list.
+		patches = append(patches, appliedConfig.patches...)
+	}
+	if patch != nil {
+		patches = append(patches, *patch)
+	}
+	replay := patches
+	if appliedConfig == nil || appliedConfig.source == "payload" {
+		replay = nil
+		if patch != nil {
+			replay = []configPatch{*patch}
+		}
+	} else if data, err = readConfigFile(appliedConfig.source); err != nil {
+		return nil, nil, err
+	}
+	for _, p := range replay {
+		if data, err = patchConfig(data, p.merge, p.ops); err != nil {
+			return nil, nil, err
+		}
+	}
+	return data, patches, nil
+}
+
+// patchConfig applies a JSON merge patch, then a JSON patch, to raw config content and returns the result as JSON.
+func patchConfig(data []byte, mergePatchDoc map[string]any, ops []PatchOperation) ([]byte, error) {
+	document, err := decodeConfigDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	if mergePatchDoc != nil {
+		document, _ = mergePatch(document, mergePatchDoc).(map[string]any)
+	}
+	if len(ops) > 0 {
+		if document, err = applyJSONPatch(document, ops); err != nil {
+			return nil, err
+		}
+	}
+
+	out, err := json.Marshal(document)
+	if err != nil {
+		return nil, contract.WrapError(contract.ErrConfigParse, err)
+	}
+	return out, nil
 }
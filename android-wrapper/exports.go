@@ -8,8 +8,8 @@ package main
 import "C"
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"unsafe"
 
 	"mihomo_android_wrapper/contract"
@@ -28,7 +28,7 @@ func invokeAction(callback unsafe.Pointer, paramsChar *C.char) {
 	if err := json.Unmarshal([]byte(params), &action); err != nil {
 		(&ActionResult{
 			Code:     -1,
-			Data:     err.Error(),
+			Data:     contract.WrapError(contract.ErrInvalidParams, err),
 			callback: callback,
 		}).send()
 		return
@@ -44,14 +44,14 @@ func invokeAction(callback unsafe.Pointer, paramsChar *C.char) {
 						ID:       action.ID,
 						Method:   action.Method,
 						Code:     -1,
-						Data:     fmt.Sprintf("panic recovered: %v", r),
+						Data:     contract.Errorf(contract.ErrInternal, "panic recovered: %v", r),
 						callback: callback,
 					}).send()
 				}
 			}
 		}()
 
-		dispatched := getDispatcher().Dispatch(action)
+		dispatched := getDispatcher().Dispatch(context.Background(), action)
 		resp := dispatched.Response
 		result := ActionResult{
 			ID:       resp.ID,
@@ -1,7 +0,0 @@
-//go:build !cgo
-
-package main
-
-// main is a placeholder for non-cgo builds.
-func main() {
-}
@@ -30,7 +30,7 @@ func (r *ActionResult) send() {
 		}{
 			ID:     r.ID,
 			Method: r.Method,
-			Data:   err.Error(),
+			Data:   contract.WrapError(contract.ErrInternal, err),
 			Code:   -1,
 		}
 		if data2, err2 := json.Marshal(fallback); err2 == nil {
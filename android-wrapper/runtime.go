@@ -8,6 +8,8 @@ import (
 	"mihomo_android_wrapper/api"
 	"mihomo_android_wrapper/contract"
 	"mihomo_android_wrapper/core"
+
+	"github.com/metacubex/mihomo/log"
 )
 
 var (
@@ -23,14 +25,22 @@ func (runtimeEmitter) Emit(message contract.Message) {
 	sendMessage(message)
 }
 
+type runtimeTun struct{}
+
+// StopTun stops the Android VPN TUN listener started via startTUN.
+func (runtimeTun) StopTun() {
+	stopTun()
+}
+
 // ensureRuntime initializes the singleton Service and Dispatcher used by exported symbols.
 func ensureRuntime() {
 	runtimeOnce.Do(func() {
 		runtimeSvc = core.New(core.Options{
 			Emitter: runtimeEmitter{},
-			StopTun: stopTun,
+			Tun:     runtimeTun{},
 		})
 		runtimeRouter = api.New(runtimeSvc)
+		runtimeRouter.Use(api.Logger(log.Debugln))
 	})
 }
 
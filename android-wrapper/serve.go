@@ -0,0 +1,63 @@
+//go:build !cgo
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"mihomo_android_wrapper/api"
+	"mihomo_android_wrapper/core"
+	"mihomo_android_wrapper/server"
+
+	"github.com/sirupsen/logrus"
+)
+
+// main runs the core as a standalone process serving the invokeAction contract
+// over a Unix domain socket or stdin/stdout (line-delimited JSON).
+func main() {
+	socketPath := flag.String("socket", "", "serve on this Unix domain socket path")
+	stdio := flag.Bool("stdio", false, "serve a single client on stdin/stdout")
+	flag.Parse()
+
+	if (*socketPath == "") == !*stdio {
+		fmt.Fprintln(os.Stderr, "exactly one of -socket or -stdio is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// stdout may carry the protocol; keep mihomo logs off it.
+	logrus.SetOutput(os.Stderr)
+
+	srv := &server.Server{}
+	srv.Dispatcher = api.New(core.New(core.Options{Emitter: srv}))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *stdio {
+		if err := srv.ServeConn(ctx, os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	_ = os.Remove(*socketPath)
+	l, err := net.Listen("unix", *socketPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer os.Remove(*socketPath)
+
+	if err := srv.Serve(ctx, l); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,175 @@
+// Package server exposes an api.Dispatcher over line-delimited JSON streams
+// (Unix domain sockets or stdin/stdout), for hosts that cannot call the cgo exports.
+//
+// Each request line is a contract.Action. Each response line is a contract.Response with
+// the same id; events are written as responses with method "message" and a contract.Message
+// as data, exactly as delivered to the invokeAction event listener.
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"mihomo_android_wrapper/api"
+	"mihomo_android_wrapper/contract"
+)
+
+// maxLineSize bounds a single request line; setupConfig payloads can be large.
+const maxLineSize = 64 << 20
+
+// Server dispatches actions read from connected clients and broadcasts events to all of them.
+// The zero value is ready to use once Dispatcher is set.
+type Server struct {
+	Dispatcher *api.Dispatcher
+
+	mu      sync.Mutex
+	clients map[*client]struct{}
+}
+
+type client struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	// cancel stops the client's in-flight actions once nothing can be written to it.
+	cancel context.CancelFunc
+}
+
+// write encodes v as one line; writes from concurrent actions and events are serialized. A failed
+// write cancels the client's actions.
+func (c *client) write(v any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.enc.Encode(v)
+	if err != nil {
+		c.cancel()
+	}
+	return err
+}
+
+// Emit implements contract.Emitter by forwarding the message to every connected client.
+func (s *Server) Emit(message contract.Message) {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	resp := contract.Response{
+		Method: contract.MessageMethod,
+		Data:   message,
+	}
+	for _, c := range clients {
+		_ = c.write(resp)
+	}
+}
+
+// Serve accepts connections on l and serves each of them until ctx is done or l fails.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			connCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			go func() {
+				<-connCtx.Done()
+				_ = conn.Close()
+			}()
+			_ = s.ServeConn(connCtx, conn, conn)
+		}()
+	}
+}
+
+// ServeConn serves one client reading actions from r and writing responses and events to w.
+// It returns once r reached EOF or failed and the client's in-flight actions finished, so a client
+// that closes its side after writing still gets every response. The actions are cancelled only
+// when ctx is done or a write to w fails.
+func (s *Server) ServeConn(ctx context.Context, r io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	c := &client{enc: json.NewEncoder(w), cancel: cancel}
+	c.enc.SetEscapeHTML(false)
+
+	s.mu.Lock()
+	if s.clients == nil {
+		s.clients = make(map[*client]struct{})
+	}
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		cancel()
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+	}()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var action contract.Action
+		if err := json.Unmarshal(line, &action); err != nil {
+			_ = c.write(contract.Response{
+				Data: contract.WrapError(contract.ErrInvalidParams, err),
+				Code: -1,
+			})
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(ctx, c, action)
+		}()
+	}
+
+	err := scanner.Err()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// handle dispatches one action and writes its response, then runs AfterSend.
+func (s *Server) handle(ctx context.Context, c *client, action contract.Action) {
+	result := s.Dispatcher.Dispatch(ctx, action)
+	if err := c.write(result.Response); err != nil {
+		// Keep the response shape stable even if data cannot be encoded.
+		_ = c.write(contract.Response{
+			ID:     action.ID,
+			Method: action.Method,
+			Data:   contract.WrapError(contract.ErrInternal, err),
+			Code:   -1,
+		})
+	}
+	if result.AfterSend != nil {
+		result.AfterSend()
+	}
+}
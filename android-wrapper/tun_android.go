@@ -147,8 +147,10 @@ func startTUN(callback unsafe.Pointer, fd C.int, stackChar, addressChar, dnsChar
 
 	if err := startTunLocked(callback, int(fd), stack, address, dns); err != nil {
 		log.Errorln("[TUN] start failed: %s", err.Error())
+		getService().NotifyTun(false)
 		return false
 	}
+	getService().NotifyTun(true)
 	return true
 }
 
@@ -159,4 +161,5 @@ func stopTun() {
 	tunMu.Lock()
 	defer tunMu.Unlock()
 	stopTunLocked()
+	getService().NotifyTun(false)
 }